@@ -0,0 +1,82 @@
+package leveldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// IncrBy adds delta to the counter stored in key and returns the new value.
+// Counters are 8-byte big-endian int64 values, a missing key is 0.
+// It is atomic with the other read-modify-write functions of DB, see Update.
+// On errors the counter is not changed and 0 is returned.
+func (db *DB) IncrBy(key []byte, delta int64) (int64, error) {
+	var n int64
+	err := db.Update(key, func(old []byte) ([]byte, error) {
+		if old != nil && len(old) != 8 {
+			return nil, db.errNotCounter(key, old)
+		}
+
+		if old != nil {
+			n = int64(binary.BigEndian.Uint64(old))
+		}
+		n += delta
+
+		return binary.BigEndian.AppendUint64(nil, uint64(n)), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// IncrByFloat is IncrBy for counters stored as 8-byte big-endian IEEE 754
+// float64 values.
+func (db *DB) IncrByFloat(key []byte, delta float64) (float64, error) {
+	var f float64
+	err := db.Update(key, func(old []byte) ([]byte, error) {
+		if old != nil && len(old) != 8 {
+			return nil, db.errNotCounter(key, old)
+		}
+
+		if old != nil {
+			f = math.Float64frombits(binary.BigEndian.Uint64(old))
+		}
+		f += delta
+
+		return binary.BigEndian.AppendUint64(nil, math.Float64bits(f)), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return f, nil
+}
+
+func (db *DB) errNotCounter(key []byte, value []byte) error {
+	return newError(ErrInvalidArgument, db.cfg.Path,
+		fmt.Sprintf("value of key %q has %d bytes, not an 8-byte counter", key, len(value)))
+}
+
+// CompareAndSwap sets key to value if its value is old, or deletes it if
+// value is nil. A nil old expects key to not exist, an empty one expects an empty
+// value. It fails with ErrCASMismatch if the value is different.
+// It is atomic with the other read-modify-write functions of DB, see Update.
+func (db *DB) CompareAndSwap(key []byte, old []byte, value []byte) error {
+	return db.Update(key, func(cur []byte) ([]byte, error) {
+		if (cur == nil) != (old == nil) || !bytes.Equal(cur, old) {
+			return nil, newError(ErrCASMismatch, db.cfg.Path,
+				fmt.Sprintf("value of key %q does not match", key))
+		}
+		return value, nil
+	})
+}
+
+// SetIfAbsent sets key to value only if key does not exist,
+// otherwise it fails with ErrCASMismatch.
+func (db *DB) SetIfAbsent(key []byte, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	return db.CompareAndSwap(key, nil, value)
+}
@@ -0,0 +1,96 @@
+package leveldb
+
+// AutoFlushBatch is a Writer collecting writes in a WriteBatch which is
+// committed whenever it reaches maxOps operations or maxBytes bytes,
+// for bulk writes too large for one batch. The batch as a whole is not
+// atomic, only each commit is.
+//
+// After the first error all writes fail with it, it is also returned by
+// Flush and Err.
+type AutoFlushBatch struct {
+	wb *WriteBatch
+
+	maxOps   int
+	maxBytes int
+
+	committed int
+
+	err error
+}
+
+// NewAutoFlushBatch returns an AutoFlushBatch writing to db, maxOps or
+// maxBytes <= 0 has no limit on operations or bytes.
+func (db *DB) NewAutoFlushBatch(maxOps int, maxBytes int) *AutoFlushBatch {
+	return &AutoFlushBatch{
+		wb:       db.NewWriteBatch(),
+		maxOps:   maxOps,
+		maxBytes: maxBytes,
+	}
+}
+
+func (b *AutoFlushBatch) Put(key, value []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+
+	if b.err = b.wb.Put(key, value); b.err != nil {
+		return b.err
+	}
+	return b.check()
+}
+
+func (b *AutoFlushBatch) Delete(key []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+
+	if b.err = b.wb.Delete(key); b.err != nil {
+		return b.err
+	}
+	return b.check()
+}
+
+func (b *AutoFlushBatch) check() error {
+	if (b.maxOps > 0 && b.wb.Count() >= b.maxOps) ||
+		(b.maxBytes > 0 && b.wb.ApproximateSize() >= b.maxBytes) {
+		return b.Flush()
+	}
+	return nil
+}
+
+// Flush commits the pending writes, call it after the last write.
+func (b *AutoFlushBatch) Flush() error {
+	if b.err != nil {
+		return b.err
+	} else if b.wb.Count() == 0 {
+		return nil
+	}
+
+	if b.err = b.wb.Commit(); b.err != nil {
+		return b.err
+	}
+
+	b.committed += b.wb.Count()
+	b.wb.Rollback()
+	return nil
+}
+
+// Committed returns the number of operations committed so far.
+func (b *AutoFlushBatch) Committed() int {
+	return b.committed
+}
+
+// Pending returns the number of operations waiting for the next commit.
+func (b *AutoFlushBatch) Pending() int {
+	return b.wb.Count()
+}
+
+// Err returns the first error of a write or commit.
+func (b *AutoFlushBatch) Err() error {
+	return b.err
+}
+
+// Close releases the batch, discarding writes which were not flushed.
+func (b *AutoFlushBatch) Close() {
+	b.wb.Close()
+}
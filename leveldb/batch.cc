@@ -0,0 +1,19 @@
+#include "go_leveldb.h"
+#include "_cgo_export.h"
+
+namespace {
+
+void Put(void* state, const char* k, size_t klen, const char* v, size_t vlen) {
+  goBatchPut(reinterpret_cast<uintptr_t>(state), const_cast<char*>(k), klen,
+             const_cast<char*>(v), vlen);
+}
+
+void Deleted(void* state, const char* k, size_t klen) {
+  goBatchDelete(reinterpret_cast<uintptr_t>(state), const_cast<char*>(k), klen);
+}
+
+}  // namespace
+
+void go_leveldb_writebatch_iterate(leveldb_writebatch_t* b, uintptr_t handle) {
+  leveldb_writebatch_iterate(b, reinterpret_cast<void*>(handle), Put, Deleted);
+}
@@ -1,40 +1,85 @@
 package leveldb
 
 // #cgo LDFLAGS: -lleveldb
-// #include "leveldb/c.h"
+// #include "go_leveldb.h"
 import "C"
 
 import (
+	"encoding/binary"
+	"runtime"
+	"runtime/cgo"
 	"unsafe"
 )
 
+// leveldb's write batch wire format, a header of a fixed64 sequence number
+// and a fixed32 count, then the records, each a type byte followed by
+// the varint32 length prefixed key and, for values, value.
+const (
+	batchHeaderSize = 12
+
+	batchTypeDeletion byte = 0
+	batchTypeValue    byte = 1
+)
+
 type WriteBatch struct {
 	db     *DB
 	wbatch *C.leveldb_writebatch_t
+
+	// leveldb's C API has no accessors for these
+	count int
+	size  int
+
+	// id in db.handles
+	handle uint64
 }
 
 func (w *WriteBatch) Close() {
+	if w.wbatch == nil {
+		return
+	}
+
+	runtime.SetFinalizer(w, nil)
+	w.close()
+}
+
+func (w *WriteBatch) close() {
 	C.leveldb_writebatch_destroy(w.wbatch)
+	w.wbatch = nil
+
+	w.db.untrackHandle(w.handle)
 }
 
-func (w *WriteBatch) Put(key, value []byte) {
-	var k, v *C.char
-	if len(key) != 0 {
-		k = (*C.char)(unsafe.Pointer(&key[0]))
-	}
-	if len(value) != 0 {
-		v = (*C.char)(unsafe.Pointer(&value[0]))
+func (w *WriteBatch) errClosed() error {
+	return newError(ErrClosed, w.db.cfg.Path, "write batch is closed")
+}
+
+func (w *WriteBatch) Put(key, value []byte) error {
+	if w.wbatch == nil {
+		return w.errClosed()
 	}
 
 	lenk := len(key)
 	lenv := len(value)
 
-	C.leveldb_writebatch_put(w.wbatch, k, C.size_t(lenk), v, C.size_t(lenv))
+	C.leveldb_writebatch_put(w.wbatch, bytesPtr(key), C.size_t(lenk), bytesPtr(value), C.size_t(lenv))
+	runtime.KeepAlive(w)
+
+	w.count++
+	w.size += 1 + varintLen(lenk) + lenk + varintLen(lenv) + lenv
+	return nil
 }
 
-func (w *WriteBatch) Delete(key []byte) {
-	C.leveldb_writebatch_delete(w.wbatch,
-		(*C.char)(unsafe.Pointer(&key[0])), C.size_t(len(key)))
+func (w *WriteBatch) Delete(key []byte) error {
+	if w.wbatch == nil {
+		return w.errClosed()
+	}
+
+	C.leveldb_writebatch_delete(w.wbatch, bytesPtr(key), C.size_t(len(key)))
+	runtime.KeepAlive(w)
+
+	w.count++
+	w.size += 1 + varintLen(len(key)) + len(key)
+	return nil
 }
 
 func (w *WriteBatch) Commit() error {
@@ -46,14 +91,201 @@ func (w *WriteBatch) SyncCommit() error {
 }
 
 func (w *WriteBatch) Rollback() {
+	if w.wbatch == nil {
+		return
+	}
+
 	C.leveldb_writebatch_clear(w.wbatch)
+	runtime.KeepAlive(w)
+	w.count = 0
+	w.size = 0
 }
 
 func (w *WriteBatch) commit(wb *WriteOptions) error {
+	if w.wbatch == nil {
+		return w.errClosed()
+	}
+
+	if err := w.db.acquire(); err != nil {
+		return err
+	}
+	defer w.db.release()
+
 	var errStr *C.char
 	C.leveldb_write(w.db.db, wb.Opt, w.wbatch, &errStr)
+	runtime.KeepAlive(w)
 	if errStr != nil {
-		return saveError(errStr)
+		return saveError(w.db.cfg.Path, errStr)
 	}
 	return nil
 }
+
+// Count returns the number of Put and Delete operations in the batch.
+func (w *WriteBatch) Count() int {
+	return w.count
+}
+
+// ApproximateSize returns the size of the batch in leveldb's wire format,
+// as returned by Data.
+func (w *WriteBatch) ApproximateSize() int {
+	return batchHeaderSize + w.size
+}
+
+type batchReplay struct {
+	w   Writer
+	err error
+}
+
+// Iterate replays the operations of the batch in order onto h, stopping at
+// the first error, which is returned. The slices passed to h point to
+// leveldb's memory and must not be retained.
+func (w *WriteBatch) Iterate(h Writer) error {
+	if w.wbatch == nil {
+		return w.errClosed()
+	}
+
+	state := &batchReplay{w: h}
+	handle := cgo.NewHandle(state)
+	defer handle.Delete()
+
+	C.go_leveldb_writebatch_iterate(w.wbatch, C.uintptr_t(handle))
+	runtime.KeepAlive(w)
+	return state.err
+}
+
+//export goBatchPut
+func goBatchPut(h C.uintptr_t, key *C.char, klen C.size_t, value *C.char, vlen C.size_t) {
+	state := cgo.Handle(h).Value().(*batchReplay)
+	if state.err == nil {
+		state.err = state.w.Put(slice(unsafe.Pointer(key), int(klen)), slice(unsafe.Pointer(value), int(vlen)))
+	}
+}
+
+//export goBatchDelete
+func goBatchDelete(h C.uintptr_t, key *C.char, klen C.size_t) {
+	state := cgo.Handle(h).Value().(*batchReplay)
+	if state.err == nil {
+		state.err = state.w.Delete(slice(unsafe.Pointer(key), int(klen)))
+	}
+}
+
+// Data encodes the batch in leveldb's wire format, with sequence number 0,
+// so it can be stored, sent elsewhere and loaded with SetData.
+func (w *WriteBatch) Data() ([]byte, error) {
+	e := &batchEncoder{data: make([]byte, batchHeaderSize, w.ApproximateSize())}
+	if err := w.Iterate(e); err != nil {
+		return nil, err
+	}
+
+	binary.LittleEndian.PutUint32(e.data[8:], uint32(e.count))
+	return e.data, nil
+}
+
+// SetData replaces the operations in the batch with those encoded in data.
+// The batch is not changed if data is malformed.
+func (w *WriteBatch) SetData(data []byte) error {
+	if w.wbatch == nil {
+		return w.errClosed()
+	}
+
+	ops, err := decodeBatch(w.db.cfg.Path, data)
+	if err != nil {
+		return err
+	}
+
+	w.Rollback()
+	for _, op := range ops {
+		if op.deleted {
+			w.Delete(op.key)
+		} else {
+			w.Put(op.key, op.value)
+		}
+	}
+	return nil
+}
+
+type batchEncoder struct {
+	data  []byte
+	count int
+}
+
+func (e *batchEncoder) Put(key, value []byte) error {
+	e.data = append(e.data, batchTypeValue)
+	e.data = binary.AppendUvarint(e.data, uint64(len(key)))
+	e.data = append(e.data, key...)
+	e.data = binary.AppendUvarint(e.data, uint64(len(value)))
+	e.data = append(e.data, value...)
+	e.count++
+	return nil
+}
+
+func (e *batchEncoder) Delete(key []byte) error {
+	e.data = append(e.data, batchTypeDeletion)
+	e.data = binary.AppendUvarint(e.data, uint64(len(key)))
+	e.data = append(e.data, key...)
+	e.count++
+	return nil
+}
+
+// decodeBatch returns the operations in data, whose keys and values point
+// into it. The errors are those of leveldb for the same problems.
+func decodeBatch(path string, data []byte) ([]memKV, error) {
+	if len(data) < batchHeaderSize {
+		return nil, newError(ErrCorruption, path, "malformed WriteBatch (too small)")
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[8:]))
+
+	readSlice := func() ([]byte, bool) {
+		n, m := binary.Uvarint(data)
+		if m <= 0 || n > uint64(len(data)-m) {
+			return nil, false
+		}
+
+		b := data[m : m+int(n)]
+		data = data[m+int(n):]
+		return b, true
+	}
+
+	var ops []memKV
+	data = data[batchHeaderSize:]
+	for len(data) > 0 {
+		var op memKV
+		var ok bool
+
+		tag := data[0]
+		data = data[1:]
+
+		switch tag {
+		case batchTypeValue:
+			if op.key, ok = readSlice(); ok {
+				op.value, ok = readSlice()
+			}
+		case batchTypeDeletion:
+			op.key, ok = readSlice()
+			op.deleted = true
+		default:
+			return nil, newError(ErrCorruption, path, "unknown WriteBatch tag")
+		}
+
+		if !ok {
+			return nil, newError(ErrCorruption, path, "bad WriteBatch record")
+		}
+		ops = append(ops, op)
+	}
+
+	if len(ops) != count {
+		return nil, newError(ErrCorruption, path, "WriteBatch has wrong count")
+	}
+	return ops, nil
+}
+
+// varintLen returns the bytes of the varint encoding of n.
+func varintLen(n int) int {
+	l := 1
+	for n >= 0x80 {
+		n >>= 7
+		l++
+	}
+	return l
+}
@@ -0,0 +1,132 @@
+package leveldb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// prefixBloomFilter is leveldb's bloom filter, computed over
+// at most the first prefixLen bytes of every key.
+type prefixBloomFilter struct {
+	prefixLen  int
+	bitsPerKey int
+
+	//number of probes
+	k int
+}
+
+// NewPrefixBloomFilter returns a bloom filter which only hashes the first
+// prefixLen bytes of keys, so keys sharing a prefix share filter bits.
+// Keys shorter than prefixLen are hashed whole.
+//
+// leveldb consults filter policies only for point reads like Get, Has and
+// MultiGet, never for iterators, so this does not make PrefixIterator or any
+// other scan faster. Reads of missing keys are still skipped if no key with
+// their prefix exists in a table, but any key of an existing prefix matches,
+// which makes it less selective than the default bloom filter.
+func NewPrefixBloomFilter(prefixLen int, bitsPerKey int) Filter {
+	f := &prefixBloomFilter{prefixLen: prefixLen, bitsPerKey: bitsPerKey}
+
+	//0.69 =~ ln(2), which minimizes the false positive rate
+	f.k = int(float64(bitsPerKey) * 0.69)
+	if f.k < 1 {
+		f.k = 1
+	} else if f.k > 30 {
+		f.k = 30
+	}
+
+	return f
+}
+
+func (f *prefixBloomFilter) Name() string {
+	return fmt.Sprintf("go-leveldb.PrefixBloomFilter%d", f.prefixLen)
+}
+
+func (f *prefixBloomFilter) prefix(key []byte) []byte {
+	if len(key) > f.prefixLen {
+		return key[:f.prefixLen]
+	}
+	return key
+}
+
+func (f *prefixBloomFilter) CreateFilter(keys [][]byte) []byte {
+	bits := len(keys) * f.bitsPerKey
+	if bits < 64 {
+		bits = 64
+	}
+
+	n := (bits + 7) / 8
+	bits = n * 8
+
+	filter := make([]byte, n+1)
+	filter[n] = byte(f.k)
+
+	for _, key := range keys {
+		h := bloomHash(f.prefix(key))
+		delta := h>>17 | h<<15
+		for j := 0; j < f.k; j++ {
+			pos := h % uint32(bits)
+			filter[pos/8] |= 1 << (pos % 8)
+			h += delta
+		}
+	}
+
+	return filter
+}
+
+func (f *prefixBloomFilter) KeyMayMatch(key []byte, filter []byte) bool {
+	if len(filter) < 2 {
+		return false
+	}
+
+	bits := uint32(len(filter)-1) * 8
+
+	//reserved for new encodings
+	k := int(filter[len(filter)-1])
+	if k > 30 {
+		return true
+	}
+
+	h := bloomHash(f.prefix(key))
+	delta := h>>17 | h<<15
+	for j := 0; j < k; j++ {
+		pos := h % bits
+		if filter[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+
+	return true
+}
+
+// bloomHash is leveldb's Hash(key, 0xbc9f1d34), similar to murmur hash.
+func bloomHash(b []byte) uint32 {
+	const (
+		seed = 0xbc9f1d34
+		m    = 0xc6a4a793
+	)
+
+	h := uint32(seed) ^ uint32(len(b))*m
+
+	for ; len(b) >= 4; b = b[4:] {
+		h += binary.LittleEndian.Uint32(b)
+		h *= m
+		h ^= h >> 16
+	}
+
+	switch len(b) {
+	case 3:
+		h += uint32(b[2]) << 16
+		fallthrough
+	case 2:
+		h += uint32(b[1]) << 8
+		fallthrough
+	case 1:
+		h += uint32(b[0])
+		h *= m
+		h ^= h >> 24
+	}
+
+	return h
+}
@@ -0,0 +1,102 @@
+package leveldb
+
+// #cgo LDFLAGS: -lleveldb
+// #include "leveldb/c.h"
+import "C"
+
+import (
+	"bytes"
+	"context"
+)
+
+// CompactRange compacts the underlying storage for the key range [start, limit],
+// a nil start means before all keys and a nil limit means after all keys.
+// Deleted and overwritten data in the range is discarded, reclaiming disk space.
+func (db *DB) CompactRange(start, limit []byte) error {
+	if err := db.acquire(); err != nil {
+		return err
+	}
+	defer db.release()
+
+	//NULL is unbounded, but an empty limit is the empty key
+	var s, l *C.char
+	if start != nil {
+		s = bytesPtr(start)
+	}
+	if limit != nil {
+		l = bytesPtr(limit)
+	}
+
+	C.leveldb_compact_range(db.db, s, C.size_t(len(start)), l, C.size_t(len(limit)))
+	return nil
+}
+
+// CompactAll compacts the whole key space one sub-range at a time.
+// progress, if not nil, is called after each sub-range is compacted.
+// ctx is checked between sub-ranges, so a long compaction running in
+// its own goroutine can be cancelled, in which case ctx.Err() is returned.
+func (db *DB) CompactAll(ctx context.Context, progress func(r Range)) error {
+	return db.compactRangeContext(ctx, nil, nil, progress)
+}
+
+func (db *DB) compactRangeContext(ctx context.Context, start, limit []byte, progress func(r Range)) error {
+	for _, r := range db.compactRanges(start, limit) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := db.CompactRange(r.Min, r.Max); err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress(r)
+		}
+	}
+
+	return nil
+}
+
+// compactRanges splits [min, max] into contiguous sub-ranges, one for each
+// distinct first byte of the keys in it. nil min or max means unbounded.
+// With a custom comparator the first bytes say nothing about the key order,
+// so [min, max] is not split.
+func (db *DB) compactRanges(min []byte, max []byte) []Range {
+	if db.cmp != BytewiseComparator {
+		return []Range{{min, max, RangeClose}}
+	}
+
+	it := db.NewIterator()
+	defer it.Close()
+
+	if min == nil {
+		it.SeekToFirst()
+	} else {
+		it.Seek(min)
+	}
+
+	var rs []Range
+	start := min
+	for it.Valid() {
+		k := it.Key()
+		if len(k) == 0 {
+			it.Next()
+			continue
+		} else if k[0] == 0xff {
+			break
+		}
+
+		//all keys starting with k[0] are less than next
+		next := []byte{k[0] + 1}
+		if max != nil && bytes.Compare(next, max) >= 0 {
+			break
+		}
+
+		rs = append(rs, Range{start, next, RangeROpen})
+		start = next
+
+		it.Seek(next)
+	}
+
+	return append(rs, Range{start, max, RangeClose})
+}
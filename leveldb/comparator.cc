@@ -0,0 +1,65 @@
+#include <stdlib.h>
+#include <string>
+
+#include "leveldb/comparator.h"
+#include "leveldb/slice.h"
+
+#include "go_leveldb.h"
+#include "_cgo_export.h"
+
+namespace {
+
+// GoComparator forwards to a Go Comparator, including the key shortening
+// methods which leveldb_comparator_create does not support.
+class GoComparator : public leveldb::Comparator {
+ public:
+  GoComparator(uintptr_t handle, const char* name)
+      : handle_(handle), name_(name) {}
+
+  int Compare(const leveldb::Slice& a, const leveldb::Slice& b) const override {
+    return goComparatorCompare(handle_, const_cast<char*>(a.data()), a.size(),
+                               const_cast<char*>(b.data()), b.size());
+  }
+
+  const char* Name() const override { return name_.c_str(); }
+
+  void FindShortestSeparator(std::string* start,
+                             const leveldb::Slice& limit) const override {
+    size_t n = 0;
+    char* s = goComparatorFindShortestSeparator(
+        handle_, const_cast<char*>(start->data()), start->size(),
+        const_cast<char*>(limit.data()), limit.size(), &n);
+    if (s != nullptr) {
+      start->assign(s, n);
+      free(s);
+    }
+  }
+
+  void FindShortSuccessor(std::string* key) const override {
+    size_t n = 0;
+    char* s = goComparatorFindShortSuccessor(
+        handle_, const_cast<char*>(key->data()), key->size(), &n);
+    if (s != nullptr) {
+      key->assign(s, n);
+      free(s);
+    }
+  }
+
+ private:
+  uintptr_t handle_;
+  std::string name_;
+};
+
+}  // namespace
+
+// leveldb_options_set_comparator only uses its argument as a
+// leveldb::Comparator*, so any Comparator can be passed through it.
+leveldb_comparator_t* go_leveldb_comparator_create(uintptr_t handle,
+                                                   const char* name) {
+  leveldb::Comparator* cmp = new GoComparator(handle, name);
+  return reinterpret_cast<leveldb_comparator_t*>(cmp);
+}
+
+void go_leveldb_comparator_destroy(leveldb_comparator_t* cmp) {
+  delete reinterpret_cast<leveldb::Comparator*>(cmp);
+}
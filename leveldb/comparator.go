@@ -0,0 +1,173 @@
+package leveldb
+
+// #cgo LDFLAGS: -lleveldb
+// #cgo CXXFLAGS: -std=c++11
+// #include <stdlib.h>
+// #include "go_leveldb.h"
+import "C"
+
+import (
+	"bytes"
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+)
+
+// Comparator defines the order of keys in a database.
+//
+// The slices passed to its methods may point to memory owned by leveldb
+// and must not be retained after the method returns.
+type Comparator interface {
+	// Compare returns a value < 0, 0 or > 0 if a is less than, equal to or greater than b.
+	Compare(a, b []byte) int
+
+	// Name is stored in the database, which can not be opened later
+	// with a comparator of another name.
+	Name() string
+
+	// FindShortestSeparator returns a key k with start <= k < limit,
+	// used to shorten index keys. Returning start is always correct.
+	FindShortestSeparator(start, limit []byte) []byte
+
+	// FindShortSuccessor returns a key k >= key, used to shorten index keys.
+	// Returning key is always correct.
+	FindShortSuccessor(key []byte) []byte
+}
+
+type bytewiseComparator struct{}
+
+// BytewiseComparator is leveldb's default lexicographic key order.
+var BytewiseComparator Comparator = bytewiseComparator{}
+
+func (bytewiseComparator) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (bytewiseComparator) Name() string {
+	return "leveldb.BytewiseComparator"
+}
+
+func (bytewiseComparator) FindShortestSeparator(start, limit []byte) []byte {
+	n := len(start)
+	if len(limit) < n {
+		n = len(limit)
+	}
+
+	i := 0
+	for i < n && start[i] == limit[i] {
+		i++
+	}
+
+	//one is the prefix of the other
+	if i >= n {
+		return start
+	}
+
+	if start[i] < 0xff && start[i]+1 < limit[i] {
+		s := append([]byte{}, start[:i+1]...)
+		s[i]++
+		return s
+	}
+
+	return start
+}
+
+func (bytewiseComparator) FindShortSuccessor(key []byte) []byte {
+	for i, b := range key {
+		if b != 0xff {
+			s := append([]byte{}, key[:i+1]...)
+			s[i]++
+			return s
+		}
+	}
+
+	//key is a run of 0xff
+	return key
+}
+
+var comparators = struct {
+	sync.RWMutex
+	m map[string]Comparator
+}{m: make(map[string]Comparator)}
+
+// RegisterComparator makes cmp selectable by its name with Config.Comparator.
+func RegisterComparator(cmp Comparator) {
+	comparators.Lock()
+	comparators.m[cmp.Name()] = cmp
+	comparators.Unlock()
+}
+
+func getComparator(name string) Comparator {
+	if name == BytewiseComparator.Name() {
+		return BytewiseComparator
+	}
+
+	comparators.RLock()
+	cmp := comparators.m[name]
+	comparators.RUnlock()
+	return cmp
+}
+
+// comparator binds a Go Comparator to a leveldb comparator.
+type comparator struct {
+	Cmp *C.leveldb_comparator_t
+
+	handle cgo.Handle
+}
+
+func newComparator(cmp Comparator) *comparator {
+	c := new(comparator)
+	c.handle = cgo.NewHandle(cmp)
+
+	name := C.CString(cmp.Name())
+	defer C.free(unsafe.Pointer(name))
+
+	c.Cmp = C.go_leveldb_comparator_create(C.uintptr_t(c.handle), name)
+	return c
+}
+
+func (c *comparator) Close() {
+	C.go_leveldb_comparator_destroy(c.Cmp)
+	c.handle.Delete()
+}
+
+//export goComparatorCompare
+func goComparatorCompare(h C.uintptr_t, a *C.char, alen C.size_t, b *C.char, blen C.size_t) C.int {
+	cmp := cgo.Handle(h).Value().(Comparator)
+
+	r := cmp.Compare(slice(unsafe.Pointer(a), int(alen)), slice(unsafe.Pointer(b), int(blen)))
+	if r < 0 {
+		return -1
+	} else if r > 0 {
+		return 1
+	}
+	return 0
+}
+
+//export goComparatorFindShortestSeparator
+func goComparatorFindShortestSeparator(h C.uintptr_t, start *C.char, slen C.size_t, limit *C.char, llen C.size_t, n *C.size_t) *C.char {
+	cmp := cgo.Handle(h).Value().(Comparator)
+
+	s := slice(unsafe.Pointer(start), int(slen))
+	sep := cmp.FindShortestSeparator(s, slice(unsafe.Pointer(limit), int(llen)))
+	if bytes.Equal(sep, s) {
+		return nil
+	}
+
+	*n = C.size_t(len(sep))
+	return (*C.char)(C.CBytes(sep))
+}
+
+//export goComparatorFindShortSuccessor
+func goComparatorFindShortSuccessor(h C.uintptr_t, key *C.char, klen C.size_t, n *C.size_t) *C.char {
+	cmp := cgo.Handle(h).Value().(Comparator)
+
+	k := slice(unsafe.Pointer(key), int(klen))
+	succ := cmp.FindShortSuccessor(k)
+	if bytes.Equal(succ, k) {
+		return nil
+	}
+
+	*n = C.size_t(len(succ))
+	return (*C.char)(C.CBytes(succ))
+}
@@ -0,0 +1,111 @@
+package leveldb
+
+import (
+	"context"
+)
+
+// The Context variants return ctx.Err() once ctx is done. A single leveldb
+// call cannot be interrupted, so ctx is checked before each one: before a
+// read or write, between iterator steps and between batch commits.
+
+// GetContext is like Get, but fails with ctx.Err() if ctx is done.
+func (db *DB) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return db.Get(key)
+}
+
+// PutContext is like Put, but fails with ctx.Err() if ctx is done.
+func (db *DB) PutContext(ctx context.Context, key, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.Put(key, value)
+}
+
+// DeleteContext is like Delete, but fails with ctx.Err() if ctx is done.
+func (db *DB) DeleteContext(ctx context.Context, key []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.Delete(key)
+}
+
+// ScanContext calls fn for each key-value pair RangeLimitIterator would
+// return with the same arguments, in key order. It stops at the first error
+// returned by fn, the iterator or ctx, and returns it. The slices passed to
+// fn point to leveldb's memory and must not be retained.
+func (db *DB) ScanContext(ctx context.Context, min []byte, max []byte, rangeType uint8, offset int, count int,
+	fn func(key, value []byte) error) error {
+	return scanContext(ctx, db.RangeLimitIterator(min, max, rangeType, offset, count), fn)
+}
+
+// RevScanContext is like ScanContext, in reverse key order.
+func (db *DB) RevScanContext(ctx context.Context, min []byte, max []byte, rangeType uint8, offset int, count int,
+	fn func(key, value []byte) error) error {
+	return scanContext(ctx, db.RevRangeLimitIterator(min, max, rangeType, offset, count), fn)
+}
+
+// ClearContext is like Clear, checking ctx before each key is deleted.
+// If ctx is done the batches committed so far stay deleted and the
+// pending one is discarded.
+func (db *DB) ClearContext(ctx context.Context) error {
+	bc := db.NewAutoFlushBatch(1000, 0)
+	defer bc.Close()
+
+	err := scanContext(ctx, db.RangeIterator(nil, nil, RangeClose), func(key, value []byte) error {
+		return bc.Delete(key)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return bc.Flush()
+}
+
+// CompactRangeContext is like CompactRange, but compacts [start, limit] one
+// sub-range at a time, as CompactAll does, checking ctx between them.
+func (db *DB) CompactRangeContext(ctx context.Context, start, limit []byte) error {
+	return db.compactRangeContext(ctx, start, limit, nil)
+}
+
+// GetContext is like DB.GetContext, reading from s.
+func (s *Snapshot) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Get(key)
+}
+
+// ScanContext is like DB.ScanContext, reading from s.
+func (s *Snapshot) ScanContext(ctx context.Context, min []byte, max []byte, rangeType uint8, offset int, count int,
+	fn func(key, value []byte) error) error {
+	return scanContext(ctx, s.RangeLimitIterator(min, max, rangeType, offset, count), fn)
+}
+
+// RevScanContext is like DB.RevScanContext, reading from s.
+func (s *Snapshot) RevScanContext(ctx context.Context, min []byte, max []byte, rangeType uint8, offset int, count int,
+	fn func(key, value []byte) error) error {
+	return scanContext(ctx, s.RevRangeLimitIterator(min, max, rangeType, offset, count), fn)
+}
+
+// scanContext calls fn for each pair of it, which it closes.
+func scanContext(ctx context.Context, it *RangeLimitIterator, fn func(key, value []byte) error) error {
+	defer it.Close()
+
+	for ; it.Valid(); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := fn(it.RawKey(), it.RawValue()); err != nil {
+			return err
+		}
+	}
+
+	return it.Error()
+}
@@ -0,0 +1,130 @@
+package leveldb
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+)
+
+const cursorVersion byte = 1
+
+const (
+	cursorMinNil byte = 1 << iota
+	cursorMaxNil
+)
+
+// Cursor returns a token for the current position of the iterator, which
+// ResumeRangeLimitIterator turns into an iterator over the same range and in
+// the same direction, starting at that position. It is meant for paginated
+// scans across requests: iterate a page, then hand out Cursor.
+//
+// The position is kept as a key, so the resumed scan continues at the
+// first key at or after it, even if the key has been deleted meanwhile.
+// Cursor returns "" if the scan has reached the end of the range.
+// The token is URL-safe but not encrypted, it contains the keys in plain.
+func (it *RangeLimitIterator) Cursor() string {
+	if it.l.Offset < 0 || !it.it.Valid() {
+		return ""
+	}
+
+	key := it.it.RawKey()
+	if !it.inRange(key) {
+		return ""
+	}
+
+	var flags byte
+	if it.r.Min == nil {
+		flags |= cursorMinNil
+	}
+	if it.r.Max == nil {
+		flags |= cursorMaxNil
+	}
+
+	n := 4 + 3*binary.MaxVarintLen64 + len(key) + len(it.r.Min) + len(it.r.Max)
+	b := make([]byte, 0, n)
+	b = append(b, cursorVersion, it.direction, it.r.Type, flags)
+	for _, k := range [][]byte{key, it.r.Min, it.r.Max} {
+		b = binary.AppendUvarint(b, uint64(len(k)))
+		b = append(b, k...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+type cursor struct {
+	key       []byte
+	r         Range
+	direction uint8
+}
+
+func decodeCursor(path string, token string) (*cursor, error) {
+	errInvalid := newError(ErrInvalidArgument, path, "invalid cursor")
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) < 4 || b[0] != cursorVersion {
+		return nil, errInvalid
+	}
+
+	c := new(cursor)
+	c.direction = b[1]
+	c.r.Type = b[2]
+	flags := b[3]
+	if c.direction != IteratorForward && c.direction != IteratorBackward {
+		return nil, errInvalid
+	}
+
+	b = b[4:]
+	keys := make([][]byte, 3)
+	for i := range keys {
+		n, m := binary.Uvarint(b)
+		if m <= 0 || n > uint64(len(b)-m) {
+			return nil, errInvalid
+		}
+
+		keys[i] = append([]byte{}, b[m:m+int(n)]...)
+		b = b[m+int(n):]
+	}
+
+	if len(b) != 0 {
+		return nil, errInvalid
+	}
+
+	c.key = keys[0]
+	c.r.Min = keys[1]
+	c.r.Max = keys[2]
+	if flags&cursorMinNil > 0 {
+		c.r.Min = nil
+	}
+	if flags&cursorMaxNil > 0 {
+		c.r.Max = nil
+	}
+
+	return c, nil
+}
+
+func resumeRangeLimitIterator(i *Iterator, c *cursor, count int) *RangeLimitIterator {
+	it := rangeLimitIterator(i, &c.r, &Limit{0, count}, c.direction)
+	it.Seek(c.key)
+	return it
+}
+
+// ResumeRangeLimitIterator continues the scan of a token from
+// RangeLimitIterator.Cursor, returning at most count keys, count < 0
+// for unlimited.
+func (db *DB) ResumeRangeLimitIterator(token string, count int) (*RangeLimitIterator, error) {
+	c, err := decodeCursor(db.cfg.Path, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return resumeRangeLimitIterator(db.NewIterator(), c, count), nil
+}
+
+// ResumeRangeLimitIterator is like DB.ResumeRangeLimitIterator, reading from s.
+func (s *Snapshot) ResumeRangeLimitIterator(token string, count int) (*RangeLimitIterator, error) {
+	c, err := decodeCursor(s.db.cfg.Path, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return resumeRangeLimitIterator(s.NewIterator(), c, count), nil
+}
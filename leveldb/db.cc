@@ -0,0 +1,116 @@
+#include <stdlib.h>
+#include <string.h>
+#include <string>
+
+#include "leveldb/db.h"
+#include "leveldb/options.h"
+#include "leveldb/slice.h"
+#include "leveldb/status.h"
+
+#include "go_leveldb.h"
+
+// Same layouts as in leveldb's db/c.cc.
+struct leveldb_t {
+  leveldb::DB* rep;
+};
+
+struct leveldb_readoptions_t {
+  leveldb::ReadOptions rep;
+};
+
+namespace {
+
+void SaveError(char** errptr, const leveldb::Status& s) {
+  free(*errptr);
+  *errptr = strdup(s.ToString().c_str());
+}
+
+// Get returns whether key was found, errors other than NotFound are saved
+// in errptr.
+bool Get(leveldb_t* db, const leveldb_readoptions_t* options, const char* key,
+         size_t keylen, std::string* value, char** errptr) {
+  leveldb::Status s = db->rep->Get(options->rep, leveldb::Slice(key, keylen), value);
+  if (!s.ok() && !s.IsNotFound()) {
+    SaveError(errptr, s);
+  }
+  return s.ok();
+}
+
+// CopyValue never returns NULL, even for an empty value.
+char* CopyValue(const std::string& value) {
+  char* result = static_cast<char*>(malloc(value.size() + 1));
+  memcpy(result, value.data(), value.size());
+  return result;
+}
+
+}  // namespace
+
+char* go_leveldb_get(leveldb_t* db, const leveldb_readoptions_t* options,
+                     const char* key, size_t keylen, size_t* vallen,
+                     unsigned char* found, char** errptr) {
+  std::string tmp;
+  *found = Get(db, options, key, keylen, &tmp, errptr);
+  *vallen = tmp.size();
+  return *found ? CopyValue(tmp) : nullptr;
+}
+
+char* go_leveldb_get_into(leveldb_t* db, const leveldb_readoptions_t* options,
+                          const char* key, size_t keylen, char* dst,
+                          size_t dstcap, size_t* vallen, unsigned char* found,
+                          char** errptr) {
+  std::string tmp;
+  *found = Get(db, options, key, keylen, &tmp, errptr);
+  *vallen = tmp.size();
+  if (!*found) {
+    return nullptr;
+  } else if (tmp.size() > dstcap) {
+    return CopyValue(tmp);
+  }
+
+  if (!tmp.empty()) {
+    memcpy(dst, tmp.data(), tmp.size());
+  }
+  return nullptr;
+}
+
+char* go_leveldb_multi_get(leveldb_t* db, const leveldb_readoptions_t* options,
+                           const char* keys, const size_t* keylens, size_t n,
+                           size_t* vallens, unsigned char* found,
+                           size_t* total, char** errptr) {
+  leveldb::ReadOptions ro = options->rep;
+  const leveldb::Snapshot* snapshot = nullptr;
+  if (ro.snapshot == nullptr) {
+    snapshot = db->rep->GetSnapshot();
+    ro.snapshot = snapshot;
+  }
+
+  std::string values;
+  std::string tmp;
+  leveldb::Status s;
+  for (size_t i = 0; i < n; i++) {
+    vallens[i] = 0;
+    found[i] = 0;
+
+    s = db->rep->Get(ro, leveldb::Slice(keys, keylens[i]), &tmp);
+    keys += keylens[i];
+    if (s.ok()) {
+      found[i] = 1;
+      vallens[i] = tmp.size();
+      values.append(tmp);
+    } else if (!s.IsNotFound()) {
+      break;
+    }
+  }
+
+  if (snapshot != nullptr) {
+    db->rep->ReleaseSnapshot(snapshot);
+  }
+
+  if (!s.ok() && !s.IsNotFound()) {
+    SaveError(errptr, s);
+    return nullptr;
+  }
+
+  *total = values.size();
+  return CopyValue(values);
+}
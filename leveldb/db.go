@@ -3,17 +3,26 @@ package leveldb
 /*
 #cgo LDFLAGS: -lleveldb
 #include <leveldb/c.h>
+#include "go_leveldb.h"
 */
 import "C"
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
 const defaultFilterBits int = 10
 
+//set in DB.refs once Close is called, below it are the references
+const refsClosing int64 = 1 << 62
+
 type Config struct {
 	Path string `json:"path"`
 
@@ -22,6 +31,30 @@ type Config struct {
 	WriteBufferSize int  `json:"write_buffer_size"`
 	CacheSize       int  `json:"cache_size"`
 	MaxOpenFiles    int  `json:"max_open_files"`
+
+	//name of a comparator registered with RegisterComparator,
+	//empty for the default bytewise order
+	Comparator string `json:"comparator"`
+
+	//bloom (default), prefix_bloom or the name of a filter
+	//registered with RegisterFilter
+	FilterPolicy string `json:"filter_policy"`
+
+	//bits per key for bloom and prefix_bloom, default 10
+	FilterBitsPerKey int `json:"filter_bits_per_key"`
+
+	//number of leading key bytes hashed by prefix_bloom
+	FilterPrefixLen int `json:"filter_prefix_len"`
+
+	//receives leveldb's info log instead of the LOG file in Path
+	Logger Logger `json:"-"`
+
+	//leveldb's default Env if nil, the DB does not close it
+	Env *Env `json:"-"`
+
+	//record where iterators, snapshots and write batches are created,
+	//for OpenHandles and the leak warnings sent to Logger
+	DebugHandles bool `json:"debug_handles"`
 }
 
 type DB struct {
@@ -41,6 +74,26 @@ type DB struct {
 	cache *Cache
 
 	filter *FilterPolicy
+
+	cmp        Comparator
+	comparator *comparator
+
+	logger *logger
+
+	//serializes Txn commits
+	txnLock sync.Mutex
+
+	keyLocks keyLocks
+
+	//open iterators, snapshots and write batches
+	handles handles
+
+	//calls, iterators and snapshots using db, and refsClosing
+	refs      atomic.Int64
+	closing   atomic.Bool
+	closeOnce sync.Once
+	//closed when the resources are released
+	done chan struct{}
 }
 
 func Open(configJson json.RawMessage) (*DB, error) {
@@ -54,14 +107,18 @@ func Open(configJson json.RawMessage) (*DB, error) {
 }
 
 func OpenWithConfig(cfg *Config) (*DB, error) {
-	if err := os.MkdirAll(cfg.Path, os.ModePerm); err != nil {
-		return nil, err
+	if cfg.Env == nil || !cfg.Env.memory {
+		if err := os.MkdirAll(cfg.Path, os.ModePerm); err != nil {
+			return nil, err
+		}
 	}
 
 	db := new(DB)
 	db.cfg = cfg
 
 	if err := db.open(); err != nil {
+		//release the comparator, filter and logger handles
+		db.Close()
 		return nil, err
 	}
 
@@ -69,7 +126,11 @@ func OpenWithConfig(cfg *Config) (*DB, error) {
 }
 
 func (db *DB) open() error {
-	db.initOptions(db.cfg)
+	db.done = make(chan struct{})
+
+	if err := db.initOptions(db.cfg); err != nil {
+		return err
+	}
 
 	var errStr *C.char
 	ldbname := C.CString(db.cfg.Path)
@@ -78,7 +139,7 @@ func (db *DB) open() error {
 	db.db = C.leveldb_open(db.opts.Opt, ldbname, &errStr)
 	if errStr != nil {
 		db.db = nil
-		return saveError(errStr)
+		return saveError(db.cfg.Path, errStr)
 	}
 	return nil
 }
@@ -93,6 +154,9 @@ func Repair(cfg *Config) error {
 	//open ok, do not need repair
 	if err == nil {
 		return nil
+	} else if db.opts == nil {
+		//invalid config, there are no options to repair with
+		return err
 	}
 
 	var errStr *C.char
@@ -101,16 +165,53 @@ func Repair(cfg *Config) error {
 
 	C.leveldb_repair_db(db.opts.Opt, ldbname, &errStr)
 	if errStr != nil {
-		return saveError(errStr)
+		return saveError(db.cfg.Path, errStr)
 	}
 	return nil
 }
 
-func (db *DB) initOptions(cfg *Config) {
+func (db *DB) initOptions(cfg *Config) error {
+	db.cmp = BytewiseComparator
+	if len(cfg.Comparator) > 0 {
+		if db.cmp = getComparator(cfg.Comparator); db.cmp == nil {
+			return newError(ErrInvalidArgument, cfg.Path,
+				fmt.Sprintf("comparator %s is not registered", cfg.Comparator))
+		}
+	}
+
+	if cfg.FilterBitsPerKey <= 0 {
+		cfg.FilterBitsPerKey = defaultFilterBits
+	}
+
+	//nil for leveldb's builtin bloom filter
+	var filter Filter
+	switch cfg.FilterPolicy {
+	case "", BloomFilterPolicy:
+	case PrefixBloomFilterPolicy:
+		if cfg.FilterPrefixLen <= 0 {
+			return newError(ErrInvalidArgument, cfg.Path, "prefix_bloom needs filter_prefix_len > 0")
+		}
+		filter = NewPrefixBloomFilter(cfg.FilterPrefixLen, cfg.FilterBitsPerKey)
+	default:
+		if filter = getFilter(cfg.FilterPolicy); filter == nil {
+			return newError(ErrInvalidArgument, cfg.Path,
+				fmt.Sprintf("filter policy %s is not registered", cfg.FilterPolicy))
+		}
+	}
+
 	opts := NewOptions()
 
 	opts.SetCreateIfMissing(true)
 
+	if cfg.Env != nil {
+		opts.SetEnv(cfg.Env)
+	}
+
+	if db.cmp != BytewiseComparator {
+		db.comparator = newComparator(db.cmp)
+		opts.SetComparator(db.comparator.Cmp)
+	}
+
 	if cfg.CacheSize <= 0 {
 		cfg.CacheSize = 4 * 1024 * 1024
 	}
@@ -118,8 +219,16 @@ func (db *DB) initOptions(cfg *Config) {
 	db.cache = NewLRUCache(cfg.CacheSize)
 	opts.SetCache(db.cache)
 
-	//we must use bloomfilter
-	db.filter = NewBloomFilter(defaultFilterBits)
+	if cfg.Logger != nil {
+		db.logger = newLogger(cfg.Logger)
+		opts.SetInfoLog(db.logger.Log)
+	}
+
+	if filter == nil {
+		db.filter = NewBloomFilter(cfg.FilterBitsPerKey)
+	} else {
+		db.filter = NewFilterPolicy(filter)
+	}
 	opts.SetFilterPolicy(db.filter)
 
 	if !cfg.Compression {
@@ -154,9 +263,77 @@ func (db *DB) initOptions(cfg *Config) {
 
 	db.syncWriteOpts = NewWriteOptions()
 	db.syncWriteOpts.SetSync(true)
+
+	return nil
 }
 
+//Close waits for all calls in progress, iterators and snapshots to be
+//closed, then closes the database, new calls fail with ErrClosed.
+//Calling it again waits for the first call, so Close must not be
+//called while holding an iterator or snapshot.
 func (db *DB) Close() error {
+	return db.CloseContext(context.Background())
+}
+
+//like Close, but gives up waiting when ctx is done and returns ctx.Err(),
+//the database is then closed when the last reference is released
+func (db *DB) CloseContext(ctx context.Context) error {
+	//never opened
+	if db.done == nil {
+		return nil
+	}
+
+	if db.closing.CompareAndSwap(false, true) {
+		if db.refs.Add(refsClosing) == refsClosing {
+			db.closeOnce.Do(db.close)
+		}
+	}
+
+	select {
+	case <-db.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//acquire takes a reference keeping db open until release
+func (db *DB) acquire() error {
+	if db.refs.Add(1)&refsClosing != 0 {
+		db.release()
+		return db.errClosed()
+	}
+	return nil
+}
+
+//acquireRead is acquire for reading with ro, which always succeeds for
+//an open snapshot, as it holds a reference until it is closed
+func (db *DB) acquireRead(ro *ReadOptions) error {
+	if ro.snap == nil {
+		return db.acquire()
+	} else if ro.snap.snap == nil {
+		return newError(ErrClosed, db.cfg.Path, "snapshot is closed")
+	}
+
+	db.refs.Add(1)
+	return nil
+}
+
+func (db *DB) release() {
+	if db.refs.Add(-1) == refsClosing {
+		db.closeOnce.Do(db.close)
+	}
+}
+
+//releases the resources once closing and without references
+func (db *DB) close() {
+	defer close(db.done)
+
+	//options were never initialized
+	if db.opts == nil {
+		return
+	}
+
 	if db.db != nil {
 		C.leveldb_close(db.db)
 		db.db = nil
@@ -172,12 +349,18 @@ func (db *DB) Close() error {
 		db.filter.Close()
 	}
 
+	if db.comparator != nil {
+		db.comparator.Close()
+	}
+
+	if db.logger != nil {
+		db.logger.Close()
+	}
+
 	db.readOpts.Close()
 	db.writeOpts.Close()
 	db.iteratorOpts.Close()
 	db.syncWriteOpts.Close()
-
-	return nil
 }
 
 func (db *DB) Destroy() error {
@@ -188,40 +371,23 @@ func (db *DB) Destroy() error {
 	opts := NewOptions()
 	defer opts.Close()
 
+	if db.cfg.Env != nil {
+		opts.SetEnv(db.cfg.Env)
+	}
+
 	var errStr *C.char
 	ldbname := C.CString(path)
 	defer C.leveldb_free(unsafe.Pointer(ldbname))
 
 	C.leveldb_destroy_db(opts.Opt, ldbname, &errStr)
 	if errStr != nil {
-		return saveError(errStr)
+		return saveError(path, errStr)
 	}
 	return nil
 }
 
 func (db *DB) Clear() error {
-	bc := db.NewWriteBatch()
-	defer bc.Close()
-
-	var err error
-	it := db.NewIterator()
-	it.SeekToFirst()
-
-	num := 0
-	for ; it.Valid(); it.Next() {
-		bc.Delete(it.Key())
-		num++
-		if num == 1000 {
-			num = 0
-			if err = bc.Commit(); err != nil {
-				return err
-			}
-		}
-	}
-
-	err = bc.Commit()
-
-	return err
+	return db.ClearContext(context.Background())
 }
 
 func (db *DB) Put(key, value []byte) error {
@@ -232,10 +398,28 @@ func (db *DB) SyncPut(key, value []byte) error {
 	return db.put(db.syncWriteOpts, key, value)
 }
 
+//nil if key does not exist, a non-nil empty slice for an empty value
 func (db *DB) Get(key []byte) ([]byte, error) {
+	value, _, err := db.get(db.readOpts, key)
+	return value, err
+}
+
+//like Get, but also reports whether key exists
+func (db *DB) Lookup(key []byte) ([]byte, bool, error) {
 	return db.get(db.readOpts, key)
 }
 
+//like Get, but the value is copied into dst[:0], which is only
+//reallocated if the value does not fit in cap(dst)
+func (db *DB) GetInto(key []byte, dst []byte) ([]byte, error) {
+	return db.getInto(db.readOpts, key, dst)
+}
+
+func (db *DB) Has(key []byte) (bool, error) {
+	_, found, err := db.get(db.readOpts, key)
+	return found, err
+}
+
 func (db *DB) Delete(key []byte) error {
 	return db.delete(db.writeOpts, key)
 }
@@ -248,30 +432,67 @@ func (db *DB) NewWriteBatch() *WriteBatch {
 	wb := &WriteBatch{
 		db:     db,
 		wbatch: C.leveldb_writebatch_create(),
+		handle: db.trackHandle(HandleWriteBatch),
 	}
+	runtime.SetFinalizer(wb, finalizeWriteBatch)
 	return wb
 }
 
+//on a closed db the snapshot reads fail with ErrClosed
 func (db *DB) NewSnapshot() *Snapshot {
-	s := &Snapshot{
+	if err := db.acquire(); err != nil {
+		//reads with these fail as the snapshot is closed
+		s := &Snapshot{&snapshot{db: db}}
+		s.readOpts = &ReadOptions{snap: s.snapshot}
+		s.iteratorOpts = s.readOpts
+		return s
+	}
+
+	s := &Snapshot{&snapshot{
 		db:           db,
 		snap:         C.leveldb_create_snapshot(db.db),
 		readOpts:     NewReadOptions(),
 		iteratorOpts: NewReadOptions(),
-	}
+		handle:       db.trackHandle(HandleSnapshot),
+	}}
 
 	s.readOpts.SetSnapshot(s)
 	s.iteratorOpts.SetSnapshot(s)
 	s.iteratorOpts.SetFillCache(false)
 
+	runtime.SetFinalizer(s, finalizeSnapshot)
 	return s
 }
 
+//on a closed db the iterator is invalid and Error returns ErrClosed
 func (db *DB) NewIterator() *Iterator {
-	it := new(Iterator)
+	return db.newIterator(db.iteratorOpts)
+}
 
-	it.it = C.leveldb_create_iterator(db.db, db.iteratorOpts.Opt)
+func (db *DB) newIterator(ro *ReadOptions) *Iterator {
+	if err := db.acquireRead(ro); err != nil {
+		return &Iterator{db: db, impl: errIterator{err}, cmp: db.cmp}
+	}
+
+	return db.createIterator(ro)
+}
+
+//NewIterator for callers already holding a reference, the iterator takes
+//its own, which can not fail even while db is closing
+func (db *DB) heldIterator() *Iterator {
+	db.refs.Add(1)
+	return db.createIterator(db.iteratorOpts)
+}
+
+//the iterator owns a reference taken for it
+func (db *DB) createIterator(ro *ReadOptions) *Iterator {
+	it := new(Iterator)
+	it.db = db
+	it.cmp = db.cmp
+	it.it = C.leveldb_create_iterator(db.db, ro.Opt)
+	it.handle = db.trackHandle(HandleIterator)
 
+	runtime.SetFinalizer(it, finalizeIterator)
 	return it
 }
 
@@ -283,6 +504,16 @@ func (db *DB) RevRangeIterator(min []byte, max []byte, rangeType uint8) *RangeLi
 	return NewRevRangeLimitIterator(db.NewIterator(), &Range{min, max, rangeType}, &Limit{0, -1})
 }
 
+//iterate all keys starting with prefix, only for the default bytewise
+//comparator, which keeps them together
+func (db *DB) PrefixIterator(prefix []byte) *RangeLimitIterator {
+	return NewRangeLimitIterator(db.NewIterator(), prefixRange(prefix), &Limit{0, -1})
+}
+
+func (db *DB) RevPrefixIterator(prefix []byte) *RangeLimitIterator {
+	return NewRevRangeLimitIterator(db.NewIterator(), prefixRange(prefix), &Limit{0, -1})
+}
+
 //count < 0, unlimit
 //offset must >= 0, if < 0, will get nothing
 func (db *DB) RangeLimitIterator(min []byte, max []byte, rangeType uint8, offset int, count int) *RangeLimitIterator {
@@ -295,62 +526,97 @@ func (db *DB) RevRangeLimitIterator(min []byte, max []byte, rangeType uint8, off
 	return NewRevRangeLimitIterator(db.NewIterator(), &Range{min, max, rangeType}, &Limit{offset, count})
 }
 
+func (db *DB) errClosed() error {
+	return newError(ErrClosed, db.cfg.Path, "database is closed")
+}
+
 func (db *DB) put(wo *WriteOptions, key, value []byte) error {
-	var errStr *C.char
-	var k, v *C.char
-	if len(key) != 0 {
-		k = (*C.char)(unsafe.Pointer(&key[0]))
-	}
-	if len(value) != 0 {
-		v = (*C.char)(unsafe.Pointer(&value[0]))
+	if err := db.acquire(); err != nil {
+		return err
 	}
+	defer db.release()
 
+	var errStr *C.char
 	lenk := len(key)
 	lenv := len(value)
 	C.leveldb_put(
-		db.db, wo.Opt, k, C.size_t(lenk), v, C.size_t(lenv), &errStr)
+		db.db, wo.Opt, bytesPtr(key), C.size_t(lenk), bytesPtr(value), C.size_t(lenv), &errStr)
 
 	if errStr != nil {
-		return saveError(errStr)
+		return saveError(db.cfg.Path, errStr)
 	}
 	return nil
 }
 
-func (db *DB) get(ro *ReadOptions, key []byte) ([]byte, error) {
+func (db *DB) get(ro *ReadOptions, key []byte) ([]byte, bool, error) {
+	if err := db.acquireRead(ro); err != nil {
+		return nil, false, err
+	}
+	defer db.release()
+
 	var errStr *C.char
 	var vallen C.size_t
-	var k *C.char
-	if len(key) != 0 {
-		k = (*C.char)(unsafe.Pointer(&key[0]))
+	var found C.uchar
+	value := C.go_leveldb_get(
+		db.db, ro.Opt, bytesPtr(key), C.size_t(len(key)), &vallen, &found, &errStr)
+
+	if errStr != nil {
+		return nil, false, saveError(db.cfg.Path, errStr)
+	}
+
+	if !ucharToBool(found) {
+		return nil, false, nil
 	}
 
-	value := C.leveldb_get(
-		db.db, ro.Opt, k, C.size_t(len(key)), &vallen, &errStr)
+	defer C.leveldb_free(unsafe.Pointer(value))
+	return C.GoBytes(unsafe.Pointer(value), C.int(vallen)), true, nil
+}
+
+func (db *DB) getInto(ro *ReadOptions, key []byte, dst []byte) ([]byte, error) {
+	if err := db.acquireRead(ro); err != nil {
+		return nil, err
+	}
+	defer db.release()
+
+	var errStr *C.char
+	var vallen C.size_t
+	var found C.uchar
+	dst = dst[:cap(dst)]
+	value := C.go_leveldb_get_into(
+		db.db, ro.Opt, bytesPtr(key), C.size_t(len(key)), bytesPtr(dst), C.size_t(len(dst)), &vallen, &found, &errStr)
 
 	if errStr != nil {
-		return nil, saveError(errStr)
+		return nil, saveError(db.cfg.Path, errStr)
 	}
 
-	if value == nil {
+	if !ucharToBool(found) {
 		return nil, nil
 	}
 
+	if value == nil {
+		if dst == nil {
+			return []byte{}, nil
+		}
+		return dst[:vallen], nil
+	}
+
+	//did not fit in dst
 	defer C.leveldb_free(unsafe.Pointer(value))
 	return C.GoBytes(unsafe.Pointer(value), C.int(vallen)), nil
 }
 
 func (db *DB) delete(wo *WriteOptions, key []byte) error {
-	var errStr *C.char
-	var k *C.char
-	if len(key) != 0 {
-		k = (*C.char)(unsafe.Pointer(&key[0]))
+	if err := db.acquire(); err != nil {
+		return err
 	}
+	defer db.release()
 
+	var errStr *C.char
 	C.leveldb_delete(
-		db.db, wo.Opt, k, C.size_t(len(key)), &errStr)
+		db.db, wo.Opt, bytesPtr(key), C.size_t(len(key)), &errStr)
 
 	if errStr != nil {
-		return saveError(errStr)
+		return saveError(db.cfg.Path, errStr)
 	}
 	return nil
 }
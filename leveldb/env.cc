@@ -0,0 +1,151 @@
+#include <string>
+
+#include "leveldb/env.h"
+#include "leveldb/slice.h"
+
+#include "go_leveldb.h"
+#include "_cgo_export.h"
+
+// Same layout as in leveldb's db/c.cc, leveldb_env_destroy deletes rep
+// unless is_default is set.
+struct leveldb_env_t {
+  leveldb::Env* rep;
+  bool is_default;
+};
+
+namespace leveldb {
+// From helpers/memenv/memenv.h, which leveldb does not install.
+Env* NewMemEnv(Env* base_env);
+}  // namespace leveldb
+
+namespace {
+
+// Must match the FileOp constants in env.go.
+enum { kFileRead = 0, kFileWrite = 1, kFileSync = 2 };
+
+void Hook(uintptr_t handle, int op, const std::string& name, size_t n) {
+  goFileHook(handle, op, const_cast<char*>(name.data()), name.size(), n);
+}
+
+class HookSequentialFile : public leveldb::SequentialFile {
+ public:
+  HookSequentialFile(leveldb::SequentialFile* base, uintptr_t handle,
+                     const std::string& name)
+      : base_(base), handle_(handle), name_(name) {}
+  ~HookSequentialFile() override { delete base_; }
+
+  leveldb::Status Read(size_t n, leveldb::Slice* result,
+                       char* scratch) override {
+    Hook(handle_, kFileRead, name_, n);
+    return base_->Read(n, result, scratch);
+  }
+
+  leveldb::Status Skip(uint64_t n) override { return base_->Skip(n); }
+
+ private:
+  leveldb::SequentialFile* base_;
+  uintptr_t handle_;
+  std::string name_;
+};
+
+class HookRandomAccessFile : public leveldb::RandomAccessFile {
+ public:
+  HookRandomAccessFile(leveldb::RandomAccessFile* base, uintptr_t handle,
+                       const std::string& name)
+      : base_(base), handle_(handle), name_(name) {}
+  ~HookRandomAccessFile() override { delete base_; }
+
+  leveldb::Status Read(uint64_t offset, size_t n, leveldb::Slice* result,
+                       char* scratch) const override {
+    Hook(handle_, kFileRead, name_, n);
+    return base_->Read(offset, n, result, scratch);
+  }
+
+ private:
+  leveldb::RandomAccessFile* base_;
+  uintptr_t handle_;
+  std::string name_;
+};
+
+class HookWritableFile : public leveldb::WritableFile {
+ public:
+  HookWritableFile(leveldb::WritableFile* base, uintptr_t handle,
+                   const std::string& name)
+      : base_(base), handle_(handle), name_(name) {}
+  ~HookWritableFile() override { delete base_; }
+
+  leveldb::Status Append(const leveldb::Slice& data) override {
+    Hook(handle_, kFileWrite, name_, data.size());
+    return base_->Append(data);
+  }
+
+  leveldb::Status Close() override { return base_->Close(); }
+  leveldb::Status Flush() override { return base_->Flush(); }
+
+  leveldb::Status Sync() override {
+    Hook(handle_, kFileSync, name_, 0);
+    return base_->Sync();
+  }
+
+ private:
+  leveldb::WritableFile* base_;
+  uintptr_t handle_;
+  std::string name_;
+};
+
+// HookEnv calls a Go FileHook before the file operations of its target env.
+class HookEnv : public leveldb::EnvWrapper {
+ public:
+  HookEnv(leveldb::Env* base, uintptr_t handle)
+      : leveldb::EnvWrapper(base), handle_(handle) {}
+
+  leveldb::Status NewSequentialFile(const std::string& f,
+                                    leveldb::SequentialFile** r) override {
+    leveldb::Status s = target()->NewSequentialFile(f, r);
+    if (s.ok()) {
+      *r = new HookSequentialFile(*r, handle_, f);
+    }
+    return s;
+  }
+
+  leveldb::Status NewRandomAccessFile(const std::string& f,
+                                      leveldb::RandomAccessFile** r) override {
+    leveldb::Status s = target()->NewRandomAccessFile(f, r);
+    if (s.ok()) {
+      *r = new HookRandomAccessFile(*r, handle_, f);
+    }
+    return s;
+  }
+
+  leveldb::Status NewWritableFile(const std::string& f,
+                                  leveldb::WritableFile** r) override {
+    leveldb::Status s = target()->NewWritableFile(f, r);
+    if (s.ok()) {
+      *r = new HookWritableFile(*r, handle_, f);
+    }
+    return s;
+  }
+
+  leveldb::Status NewAppendableFile(const std::string& f,
+                                    leveldb::WritableFile** r) override {
+    leveldb::Status s = target()->NewAppendableFile(f, r);
+    if (s.ok()) {
+      *r = new HookWritableFile(*r, handle_, f);
+    }
+    return s;
+  }
+
+ private:
+  uintptr_t handle_;
+};
+
+}  // namespace
+
+leveldb_env_t* go_leveldb_memenv_create(void) {
+  return new leveldb_env_t{leveldb::NewMemEnv(leveldb::Env::Default()), false};
+}
+
+leveldb_env_t* go_leveldb_hookenv_create(leveldb_env_t* base,
+                                         uintptr_t handle) {
+  return new leveldb_env_t{new HookEnv(base->rep, handle), false};
+}
@@ -0,0 +1,82 @@
+package leveldb
+
+// #cgo LDFLAGS: -lleveldb
+// #include "go_leveldb.h"
+import "C"
+
+import (
+	"runtime/cgo"
+)
+
+type FileOp int
+
+const (
+	FileRead FileOp = iota
+	FileWrite
+	FileSync
+)
+
+func (op FileOp) String() string {
+	switch op {
+	case FileRead:
+		return "read"
+	case FileWrite:
+		return "write"
+	case FileSync:
+		return "sync"
+	}
+	return "unknown"
+}
+
+// FileHook is called before leveldb reads, writes or syncs a file,
+// n is the number of bytes to read or write. The operation waits for the
+// hook to return, so a hook can also be used to rate limit file access.
+// It may be called concurrently from leveldb's background thread.
+type FileHook func(op FileOp, name string, n int)
+
+// Env is how leveldb accesses files, an Env must not be closed
+// before all databases using it are closed.
+type Env struct {
+	Env *C.leveldb_env_t
+
+	//files are kept in memory, not on disk
+	memory bool
+
+	handle cgo.Handle
+}
+
+// NewDefaultEnv returns leveldb's default Env, which uses the local filesystem.
+func NewDefaultEnv() *Env {
+	return &Env{Env: C.leveldb_create_default_env()}
+}
+
+// NewMemEnv returns an Env which keeps all files in memory,
+// databases opened in it never touch the disk and are lost when it is closed.
+func NewMemEnv() *Env {
+	return &Env{Env: C.go_leveldb_memenv_create(), memory: true}
+}
+
+// NewHookedEnv returns an Env which calls hook before every file
+// operation of base. base must outlive the returned Env.
+func NewHookedEnv(base *Env, hook FileHook) *Env {
+	e := new(Env)
+	e.memory = base.memory
+	e.handle = cgo.NewHandle(hook)
+	e.Env = C.go_leveldb_hookenv_create(base.Env, C.uintptr_t(e.handle))
+	return e
+}
+
+func (e *Env) Close() {
+	C.leveldb_env_destroy(e.Env)
+
+	if e.handle != 0 {
+		e.handle.Delete()
+	}
+}
+
+//export goFileHook
+func goFileHook(h C.uintptr_t, op C.int, name *C.char, nameLen C.size_t, n C.size_t) {
+	hook := cgo.Handle(h).Value().(FileHook)
+
+	hook(FileOp(op), C.GoStringN(name, C.int(nameLen)), int(n))
+}
@@ -0,0 +1,79 @@
+package leveldb
+
+import (
+	"errors"
+	"strings"
+)
+
+// kinds of Error, use errors.Is to check them
+var (
+	ErrNotFound        = errors.New("leveldb: not found")
+	ErrCorruption      = errors.New("leveldb: corruption")
+	ErrIO              = errors.New("leveldb: io error")
+	ErrInvalidArgument = errors.New("leveldb: invalid argument")
+	ErrNotSupported    = errors.New("leveldb: not supported")
+	ErrClosed          = errors.New("leveldb: closed")
+
+	//the database is opened by another DB or process,
+	//it is also an ErrIO
+	ErrLockHeld = errors.New("leveldb: lock held")
+
+	//a key read by a Txn was changed before it committed
+	ErrConflict = errors.New("leveldb: transaction conflict")
+
+	//the value is not the expected one of a compare-and-swap
+	ErrCASMismatch = errors.New("leveldb: compare-and-swap mismatch")
+)
+
+// Error is returned for all failures reported by leveldb.
+type Error struct {
+	//one of the Err kinds above
+	Kind error
+
+	//database path, may be empty
+	Path string
+
+	//original leveldb message, such as "IO error: ..."
+	Msg string
+}
+
+func (e *Error) Error() string {
+	if len(e.Path) == 0 {
+		return e.Msg
+	}
+	return e.Path + ": " + e.Msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind || (e.Kind == ErrLockHeld && target == ErrIO)
+}
+
+func newError(kind error, path string, msg string) error {
+	return &Error{Kind: kind, Path: path, Msg: msg}
+}
+
+// classifyError maps a leveldb status message to its kind,
+// the prefixes are from leveldb's Status::ToString.
+func classifyError(msg string) error {
+	switch {
+	case strings.HasPrefix(msg, "NotFound: "):
+		return ErrNotFound
+	case strings.HasPrefix(msg, "Corruption: "):
+		return ErrCorruption
+	case strings.HasPrefix(msg, "Not implemented: "):
+		return ErrNotSupported
+	case strings.HasPrefix(msg, "Invalid argument: "):
+		return ErrInvalidArgument
+	case strings.HasPrefix(msg, "IO error: lock "):
+		return ErrLockHeld
+	case strings.HasPrefix(msg, "IO error: "):
+		return ErrIO
+	}
+
+	//unknown status, treat as an io error
+	return ErrIO
+}
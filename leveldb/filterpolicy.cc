@@ -0,0 +1,43 @@
+#include <stdlib.h>
+#include <string>
+
+#include "go_leveldb.h"
+#include "_cgo_export.h"
+
+namespace {
+
+struct GoFilterPolicy {
+  uintptr_t handle;
+  std::string name;
+};
+
+void Destroy(void* state) { delete static_cast<GoFilterPolicy*>(state); }
+
+const char* Name(void* state) {
+  return static_cast<GoFilterPolicy*>(state)->name.c_str();
+}
+
+char* CreateFilter(void* state, const char* const* keys,
+                   const size_t* key_lengths, int num_keys,
+                   size_t* filter_length) {
+  return goFilterCreate(static_cast<GoFilterPolicy*>(state)->handle,
+                        const_cast<char**>(keys),
+                        const_cast<size_t*>(key_lengths), num_keys,
+                        filter_length);
+}
+
+unsigned char KeyMayMatch(void* state, const char* key, size_t length,
+                          const char* filter, size_t filter_length) {
+  return goFilterKeyMayMatch(static_cast<GoFilterPolicy*>(state)->handle,
+                             const_cast<char*>(key), length,
+                             const_cast<char*>(filter), filter_length);
+}
+
+}  // namespace
+
+leveldb_filterpolicy_t* go_leveldb_filterpolicy_create(uintptr_t handle,
+                                                       const char* name) {
+  GoFilterPolicy* state = new GoFilterPolicy{handle, name};
+  return leveldb_filterpolicy_create(state, Destroy, CreateFilter, KeyMayMatch,
+                                     Name);
+}
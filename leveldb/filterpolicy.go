@@ -2,18 +2,112 @@ package leveldb
 
 // #cgo LDFLAGS: -lleveldb
 // #include <stdlib.h>
-// #include "leveldb/c.h"
+// #include "go_leveldb.h"
 import "C"
 
+import (
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+)
+
+// names of the filter policies built into Config.FilterPolicy
+const (
+	BloomFilterPolicy       = "bloom"
+	PrefixBloomFilterPolicy = "prefix_bloom"
+)
+
+// Filter is a Go implementation of a leveldb filter policy, used to skip
+// sstables which can not contain a key on Get.
+//
+// The slices passed to its methods may point to memory owned by leveldb
+// and must not be retained after the method returns.
+type Filter interface {
+	// Name is stored with every filter, sstables whose filters were built
+	// by a policy of another name are read without filtering.
+	Name() string
+
+	// CreateFilter builds a filter matching all keys.
+	CreateFilter(keys [][]byte) []byte
+
+	// KeyMayMatch returns false only if key was not in the keys
+	// the filter was created from.
+	KeyMayMatch(key []byte, filter []byte) bool
+}
+
 type FilterPolicy struct {
 	Policy *C.leveldb_filterpolicy_t
+
+	handle cgo.Handle
 }
 
 func NewBloomFilter(bitsPerKey int) *FilterPolicy {
 	policy := C.leveldb_filterpolicy_create_bloom(C.int(bitsPerKey))
-	return &FilterPolicy{policy}
+	return &FilterPolicy{Policy: policy}
+}
+
+// NewFilterPolicy returns a FilterPolicy backed by the Go filter f.
+func NewFilterPolicy(f Filter) *FilterPolicy {
+	fp := new(FilterPolicy)
+	fp.handle = cgo.NewHandle(f)
+
+	name := C.CString(f.Name())
+	defer C.free(unsafe.Pointer(name))
+
+	fp.Policy = C.go_leveldb_filterpolicy_create(C.uintptr_t(fp.handle), name)
+	return fp
 }
 
 func (fp *FilterPolicy) Close() {
 	C.leveldb_filterpolicy_destroy(fp.Policy)
+
+	if fp.handle != 0 {
+		fp.handle.Delete()
+	}
+}
+
+var filters = struct {
+	sync.RWMutex
+	m map[string]Filter
+}{m: make(map[string]Filter)}
+
+// RegisterFilter makes f selectable by its name with Config.FilterPolicy.
+func RegisterFilter(f Filter) {
+	filters.Lock()
+	filters.m[f.Name()] = f
+	filters.Unlock()
+}
+
+func getFilter(name string) Filter {
+	filters.RLock()
+	f := filters.m[name]
+	filters.RUnlock()
+	return f
+}
+
+//export goFilterCreate
+func goFilterCreate(h C.uintptr_t, keys **C.char, keyLens *C.size_t, n C.int, filterLen *C.size_t) *C.char {
+	f := cgo.Handle(h).Value().(Filter)
+
+	ks := make([][]byte, int(n))
+	if n > 0 {
+		ps := unsafe.Slice(keys, int(n))
+		ls := unsafe.Slice(keyLens, int(n))
+		for i := range ks {
+			ks[i] = slice(unsafe.Pointer(ps[i]), int(ls[i]))
+		}
+	}
+
+	filter := f.CreateFilter(ks)
+
+	*filterLen = C.size_t(len(filter))
+	return (*C.char)(C.CBytes(filter))
+}
+
+//export goFilterKeyMayMatch
+func goFilterKeyMayMatch(h C.uintptr_t, key *C.char, keyLen C.size_t, filter *C.char, filterLen C.size_t) C.uchar {
+	f := cgo.Handle(h).Value().(Filter)
+
+	return boolToUchar(f.KeyMayMatch(slice(unsafe.Pointer(key), int(keyLen)),
+		slice(unsafe.Pointer(filter), int(filterLen))))
 }
@@ -0,0 +1,53 @@
+#ifndef GO_LEVELDB_H_
+#define GO_LEVELDB_H_
+
+// Extensions to the leveldb C API, implemented in the .cc files of this package.
+
+#include <stdint.h>
+#include "leveldb/c.h"
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+// go_leveldb_get is leveldb_get, but sets *found instead of returning NULL
+// for a missing key, which it can not tell apart from an empty value.
+char* go_leveldb_get(leveldb_t* db, const leveldb_readoptions_t* options,
+                     const char* key, size_t keylen, size_t* vallen,
+                     unsigned char* found, char** errptr);
+
+// go_leveldb_get_into copies the value of key into dst if it fits in dstcap
+// bytes, otherwise it returns a copy like go_leveldb_get.
+char* go_leveldb_get_into(leveldb_t* db, const leveldb_readoptions_t* options,
+                          const char* key, size_t keylen, char* dst,
+                          size_t dstcap, size_t* vallen, unsigned char* found,
+                          char** errptr);
+
+// go_leveldb_multi_get looks up the n keys concatenated in keys, reading
+// from an implicit snapshot unless options has one. The found values are
+// concatenated in the returned buffer of *total bytes.
+char* go_leveldb_multi_get(leveldb_t* db, const leveldb_readoptions_t* options,
+                           const char* keys, const size_t* keylens, size_t n,
+                           size_t* vallens, unsigned char* found,
+                           size_t* total, char** errptr);
+
+// go_leveldb_writebatch_iterate calls the Go goBatchPut and goBatchDelete
+// for the operations in b.
+void go_leveldb_writebatch_iterate(leveldb_writebatch_t* b, uintptr_t handle);
+
+leveldb_comparator_t* go_leveldb_comparator_create(uintptr_t handle, const char* name);
+void go_leveldb_comparator_destroy(leveldb_comparator_t* cmp);
+
+leveldb_filterpolicy_t* go_leveldb_filterpolicy_create(uintptr_t handle, const char* name);
+
+leveldb_logger_t* go_leveldb_logger_create(uintptr_t handle);
+void go_leveldb_logger_destroy(leveldb_logger_t* logger);
+
+leveldb_env_t* go_leveldb_memenv_create(void);
+leveldb_env_t* go_leveldb_hookenv_create(leveldb_env_t* base, uintptr_t handle);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
@@ -0,0 +1,144 @@
+package leveldb
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+type HandleKind int
+
+const (
+	HandleIterator HandleKind = iota
+	HandleSnapshot
+	HandleWriteBatch
+)
+
+func (k HandleKind) String() string {
+	switch k {
+	case HandleIterator:
+		return "iterator"
+	case HandleSnapshot:
+		return "snapshot"
+	case HandleWriteBatch:
+		return "write batch"
+	}
+	return "unknown"
+}
+
+// HandleInfo describes an open iterator, snapshot or write batch.
+type HandleInfo struct {
+	Kind    HandleKind
+	Created time.Time
+
+	// Stack is the goroutine stack that created the handle, only recorded
+	// with Config.DebugHandles.
+	Stack string
+}
+
+// handles tracks the open handles of a DB by id. It must not refer to the
+// handles themselves, or they would never become unreachable and finalized.
+type handles struct {
+	mu   sync.Mutex
+	next uint64
+	m    map[uint64]*HandleInfo
+}
+
+// OpenHandles returns the iterators, snapshots and write batches of db
+// which are not closed yet, oldest first.
+func (db *DB) OpenHandles() []HandleInfo {
+	h := &db.handles
+
+	h.mu.Lock()
+	ids := make([]uint64, 0, len(h.m))
+	for id := range h.m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	infos := make([]HandleInfo, len(ids))
+	for i, id := range ids {
+		infos[i] = *h.m[id]
+	}
+	h.mu.Unlock()
+
+	return infos
+}
+
+// trackHandle registers a new handle and returns its id for untrackHandle.
+func (db *DB) trackHandle(kind HandleKind) uint64 {
+	info := &HandleInfo{Kind: kind, Created: time.Now()}
+	if db.cfg.DebugHandles {
+		info.Stack = string(debug.Stack())
+	}
+
+	h := &db.handles
+
+	h.mu.Lock()
+	if h.m == nil {
+		h.m = make(map[uint64]*HandleInfo)
+	}
+	h.next++
+	id := h.next
+	h.m[id] = info
+	h.mu.Unlock()
+
+	return id
+}
+
+func (db *DB) untrackHandle(id uint64) {
+	h := &db.handles
+
+	h.mu.Lock()
+	delete(h.m, id)
+	h.mu.Unlock()
+}
+
+// leakedHandle reports the handle id, found unreachable without being
+// closed, to Config.Logger. The finalizer closes it afterwards.
+func (db *DB) leakedHandle(id uint64) {
+	if db.cfg.Logger == nil {
+		return
+	}
+
+	h := &db.handles
+
+	h.mu.Lock()
+	info := h.m[id]
+	h.mu.Unlock()
+
+	if info == nil {
+		return
+	}
+
+	msg := fmt.Sprintf("leveldb: %s created at %s was not closed",
+		info.Kind, info.Created.Format(time.RFC3339))
+	if len(info.Stack) > 0 {
+		msg += "\n" + info.Stack
+	} else {
+		msg += ", set debug_handles to record where"
+	}
+
+	db.cfg.Logger.Log(LogWarn, msg)
+}
+
+// Finalizers for handles which become unreachable without being closed.
+// The handle methods use runtime.KeepAlive after their C calls, so these
+// cannot run while one is still using the C memory.
+
+func finalizeIterator(it *Iterator) {
+	it.db.leakedHandle(it.handle)
+	it.close()
+}
+
+func finalizeSnapshot(s *Snapshot) {
+	s.db.leakedHandle(s.handle)
+	s.close()
+}
+
+func finalizeWriteBatch(w *WriteBatch) {
+	w.db.leakedHandle(w.handle)
+	w.close()
+}
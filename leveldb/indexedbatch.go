@@ -0,0 +1,323 @@
+package leveldb
+
+// IndexedBatch is a WriteBatch which also keeps its pending mutations
+// in memory, so reads from it see them merged over the DB or Snapshot
+// it was created from, like RocksDB's WriteBatchWithIndex.
+//
+// An IndexedBatch is not safe for concurrent use. Its iterators see the
+// batch as of their creation.
+type IndexedBatch struct {
+	wb *WriteBatch
+
+	r   Reader
+	cmp Comparator
+
+	// pending mutations sorted by cmp
+	kvs []memKV
+
+	// kvs is used by an iterator and must be copied before changes
+	shared bool
+}
+
+// NewIndexedBatch returns an IndexedBatch reading from db.
+func (db *DB) NewIndexedBatch() *IndexedBatch {
+	return newIndexedBatch(db.NewWriteBatch(), db, db.cmp)
+}
+
+// NewIndexedBatch returns an IndexedBatch reading from s,
+// which is committed to the DB of s.
+func (s *Snapshot) NewIndexedBatch() *IndexedBatch {
+	return newIndexedBatch(s.db.NewWriteBatch(), s, s.db.cmp)
+}
+
+func newIndexedBatch(wb *WriteBatch, r Reader, cmp Comparator) *IndexedBatch {
+	return &IndexedBatch{wb: wb, r: r, cmp: cmp}
+}
+
+func (b *IndexedBatch) Close() {
+	b.wb.Close()
+	b.kvs = nil
+}
+
+func (b *IndexedBatch) Put(key, value []byte) error {
+	if err := b.wb.Put(key, value); err != nil {
+		return err
+	}
+
+	b.set(memKV{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+	return nil
+}
+
+func (b *IndexedBatch) Delete(key []byte) error {
+	if err := b.wb.Delete(key); err != nil {
+		return err
+	}
+
+	b.set(memKV{key: append([]byte{}, key...), deleted: true})
+	return nil
+}
+
+func (b *IndexedBatch) set(kv memKV) {
+	if b.shared {
+		b.kvs = append([]memKV{}, b.kvs...)
+		b.shared = false
+	}
+
+	i, found := memSearch(b.kvs, b.cmp, kv.key)
+	if found {
+		b.kvs[i] = kv
+		return
+	}
+
+	b.kvs = append(b.kvs, memKV{})
+	copy(b.kvs[i+1:], b.kvs[i:])
+	b.kvs[i] = kv
+}
+
+// Commit writes the pending mutations to the DB atomically and, if
+// that succeeded, clears them from the batch.
+func (b *IndexedBatch) Commit() error {
+	return b.commit(b.wb.Commit)
+}
+
+func (b *IndexedBatch) SyncCommit() error {
+	return b.commit(b.wb.SyncCommit)
+}
+
+func (b *IndexedBatch) commit(f func() error) error {
+	if err := f(); err != nil {
+		return err
+	}
+
+	b.Rollback()
+	return nil
+}
+
+// Rollback discards the pending mutations.
+func (b *IndexedBatch) Rollback() {
+	b.wb.Rollback()
+	b.kvs = nil
+	b.shared = false
+}
+
+func (b *IndexedBatch) Get(key []byte) ([]byte, error) {
+	value, _, err := b.Lookup(key)
+	return value, err
+}
+
+func (b *IndexedBatch) Lookup(key []byte) ([]byte, bool, error) {
+	if i, found := memSearch(b.kvs, b.cmp, key); found {
+		if b.kvs[i].deleted {
+			return nil, false, nil
+		}
+		return append([]byte{}, b.kvs[i].value...), true, nil
+	}
+
+	return b.r.Lookup(key)
+}
+
+func (b *IndexedBatch) Has(key []byte) (bool, error) {
+	if i, found := memSearch(b.kvs, b.cmp, key); found {
+		return !b.kvs[i].deleted, nil
+	}
+
+	return b.r.Has(key)
+}
+
+func (b *IndexedBatch) NewIterator() *Iterator {
+	b.shared = true
+
+	it := new(Iterator)
+	it.impl = &batchIterator{base: b.r.NewIterator(), kvs: b.kvs, cmp: b.cmp, pos: -1}
+	it.cmp = b.cmp
+	return it
+}
+
+func (b *IndexedBatch) RangeIterator(min []byte, max []byte, rangeType uint8) *RangeLimitIterator {
+	return NewRangeLimitIterator(b.NewIterator(), &Range{min, max, rangeType}, &Limit{0, -1})
+}
+
+func (b *IndexedBatch) RevRangeIterator(min []byte, max []byte, rangeType uint8) *RangeLimitIterator {
+	return NewRevRangeLimitIterator(b.NewIterator(), &Range{min, max, rangeType}, &Limit{0, -1})
+}
+
+func (b *IndexedBatch) RangeLimitIterator(min []byte, max []byte, rangeType uint8, offset int, count int) *RangeLimitIterator {
+	return NewRangeLimitIterator(b.NewIterator(), &Range{min, max, rangeType}, &Limit{offset, count})
+}
+
+func (b *IndexedBatch) RevRangeLimitIterator(min []byte, max []byte, rangeType uint8, offset int, count int) *RangeLimitIterator {
+	return NewRevRangeLimitIterator(b.NewIterator(), &Range{min, max, rangeType}, &Limit{offset, count})
+}
+
+func (b *IndexedBatch) PrefixIterator(prefix []byte) *RangeLimitIterator {
+	return NewRangeLimitIterator(b.NewIterator(), prefixRange(prefix), &Limit{0, -1})
+}
+
+func (b *IndexedBatch) RevPrefixIterator(prefix []byte) *RangeLimitIterator {
+	return NewRevRangeLimitIterator(b.NewIterator(), prefixRange(prefix), &Limit{0, -1})
+}
+
+// batchIterator merges the pending mutations in kvs over base.
+//
+// Moving forward, base and pos are at the first of their keys >= the
+// current key, which is the smaller of both. Moving backward they are at
+// the last keys <= the current key, which is the greater of both.
+// On equal keys kvs wins. Deleted keys in kvs are skipped, together with
+// the same key in base.
+type batchIterator struct {
+	base *Iterator
+
+	kvs []memKV
+	cmp Comparator
+
+	pos int
+
+	backward bool
+}
+
+func (it *batchIterator) kvValid() bool {
+	return it.pos >= 0 && it.pos < len(it.kvs)
+}
+
+// current reports whether the current key is in kvs, and whether base
+// is at the same key.
+func (it *batchIterator) current() (inKVs bool, equal bool) {
+	if !it.kvValid() {
+		return false, false
+	} else if !it.base.Valid() {
+		return true, false
+	}
+
+	r := it.cmp.Compare(it.base.RawKey(), it.kvs[it.pos].key)
+	if it.backward {
+		r = -r
+	}
+	return r >= 0, r == 0
+}
+
+// skip moves past deleted keys in the current direction.
+func (it *batchIterator) skip() {
+	for {
+		inKVs, equal := it.current()
+		if !inKVs || !it.kvs[it.pos].deleted {
+			return
+		}
+
+		it.step(true, equal)
+	}
+}
+
+// step moves the side of the current key one key in the current direction.
+func (it *batchIterator) step(inKVs bool, equal bool) {
+	if it.backward {
+		if inKVs {
+			it.pos--
+		}
+		if !inKVs || equal {
+			it.base.Prev()
+		}
+	} else {
+		if inKVs {
+			it.pos++
+		}
+		if !inKVs || equal {
+			it.base.Next()
+		}
+	}
+}
+
+func (it *batchIterator) Valid() bool {
+	return it.kvValid() || it.base.Valid()
+}
+
+func (it *batchIterator) Key() []byte {
+	if inKVs, _ := it.current(); inKVs {
+		return it.kvs[it.pos].key
+	}
+	return it.base.RawKey()
+}
+
+func (it *batchIterator) Value() []byte {
+	if inKVs, _ := it.current(); inKVs {
+		return it.kvs[it.pos].value
+	}
+	return it.base.RawValue()
+}
+
+func (it *batchIterator) Next() {
+	if !it.Valid() {
+		return
+	}
+
+	if it.backward {
+		//reposition both sides at the first keys >= the current key
+		key := copyBytes(it.Key())
+		it.base.Seek(key)
+		it.pos, _ = memSearch(it.kvs, it.cmp, key)
+		it.backward = false
+	}
+
+	it.step(it.current())
+	it.skip()
+}
+
+func (it *batchIterator) Prev() {
+	if !it.Valid() {
+		return
+	}
+
+	if !it.backward {
+		//reposition both sides at the last keys <= the current key
+		key := copyBytes(it.Key())
+		it.seekLE(key)
+		it.backward = true
+	}
+
+	it.step(it.current())
+	it.skip()
+}
+
+func (it *batchIterator) seekLE(key []byte) {
+	it.base.Seek(key)
+	if !it.base.Valid() {
+		it.base.SeekToLast()
+	} else if it.cmp.Compare(it.base.RawKey(), key) != 0 {
+		it.base.Prev()
+	}
+
+	i, found := memSearch(it.kvs, it.cmp, key)
+	if !found {
+		i--
+	}
+	it.pos = i
+}
+
+func (it *batchIterator) SeekToFirst() {
+	it.base.SeekToFirst()
+	it.pos = 0
+	it.backward = false
+	it.skip()
+}
+
+func (it *batchIterator) SeekToLast() {
+	it.base.SeekToLast()
+	it.pos = len(it.kvs) - 1
+	it.backward = true
+	it.skip()
+}
+
+func (it *batchIterator) Seek(key []byte) {
+	it.base.Seek(key)
+	it.pos, _ = memSearch(it.kvs, it.cmp, key)
+	it.backward = false
+	it.skip()
+}
+
+func (it *batchIterator) Error() error {
+	return it.base.Error()
+}
+
+func (it *batchIterator) Close() {
+	it.base.Close()
+	it.kvs = nil
+}
@@ -6,7 +6,7 @@ package leveldb
 import "C"
 
 import (
-	"bytes"
+	"runtime"
 	"unsafe"
 )
 
@@ -35,72 +35,246 @@ type Range struct {
 	Type uint8
 }
 
+//all keys starting with prefix, in the bytewise key order
+func prefixRange(prefix []byte) *Range {
+	max := prefixSuccessor(prefix)
+	if max == nil {
+		return &Range{prefix, nil, RangeClose}
+	}
+	return &Range{prefix, max, RangeROpen}
+}
+
+//smallest key greater than all keys starting with prefix,
+//nil if there is none, like for an empty or all 0xFF prefix
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			s := append([]byte{}, prefix[:i+1]...)
+			s[i]++
+			return s
+		}
+	}
+	return nil
+}
+
 type Limit struct {
 	Offset int
 	Count  int
 }
 
+//iterates data kept in Go, like MemDB, for an Iterator without a leveldb
+//iterator. Key and Value may return memory owned by it, valid until the
+//next move.
+type iteratorImpl interface {
+	Valid() bool
+	Key() []byte
+	Value() []byte
+	Next()
+	Prev()
+	SeekToFirst()
+	SeekToLast()
+	Seek(key []byte)
+	Error() error
+	Close()
+}
+
+//an always invalid iterator failing with err
+type errIterator struct {
+	err error
+}
+
+func (it errIterator) Valid() bool     { return false }
+func (it errIterator) Key() []byte     { return nil }
+func (it errIterator) Value() []byte   { return nil }
+func (it errIterator) Next()           {}
+func (it errIterator) Prev()           {}
+func (it errIterator) SeekToFirst()    {}
+func (it errIterator) SeekToLast()     {}
+func (it errIterator) Seek(key []byte) {}
+func (it errIterator) Error() error    { return it.err }
+func (it errIterator) Close()          {}
+
 type Iterator struct {
+	db *DB
+
 	it *C.leveldb_iterator_t
+
+	//used instead of it if not nil
+	impl iteratorImpl
+
+	cmp Comparator
+
+	//id in db.handles
+	handle uint64
 }
 
 func (it *Iterator) Key() []byte {
+	if it.impl != nil {
+		return copyBytes(it.impl.Key())
+	}
+
 	var klen C.size_t
 	kdata := C.leveldb_iter_key(it.it, &klen)
 	if kdata == nil {
 		return nil
 	}
 
-	return C.GoBytes(unsafe.Pointer(kdata), C.int(klen))
+	b := C.GoBytes(unsafe.Pointer(kdata), C.int(klen))
+	runtime.KeepAlive(it)
+	return b
 }
 
 func (it *Iterator) Value() []byte {
+	if it.impl != nil {
+		return copyBytes(it.impl.Value())
+	}
+
+	var vlen C.size_t
+	vdata := C.leveldb_iter_value(it.it, &vlen)
+	if vdata == nil {
+		return nil
+	}
+
+	b := C.GoBytes(unsafe.Pointer(vdata), C.int(vlen))
+	runtime.KeepAlive(it)
+	return b
+}
+
+//RawKey and RawValue return leveldb's own memory without copying,
+//which is only valid until the next move of the iterator or Close.
+//Copy it, like Key and Value do, to keep it longer.
+func (it *Iterator) RawKey() []byte {
+	if it.impl != nil {
+		return it.impl.Key()
+	}
+
+	var klen C.size_t
+	kdata := C.leveldb_iter_key(it.it, &klen)
+	runtime.KeepAlive(it)
+	if kdata == nil {
+		return nil
+	}
+
+	return slice(unsafe.Pointer(kdata), int(C.int(klen)))
+}
+
+func (it *Iterator) RawValue() []byte {
+	if it.impl != nil {
+		return it.impl.Value()
+	}
+
 	var vlen C.size_t
 	vdata := C.leveldb_iter_value(it.it, &vlen)
+	runtime.KeepAlive(it)
 	if vdata == nil {
 		return nil
 	}
 
-	return C.GoBytes(unsafe.Pointer(vdata), C.int(vlen))
+	return slice(unsafe.Pointer(vdata), int(C.int(vlen)))
 }
 
 func (it *Iterator) Close() {
+	if it.impl != nil {
+		it.impl.Close()
+		it.impl = nil
+		return
+	} else if it.it == nil {
+		return
+	}
+
+	runtime.SetFinalizer(it, nil)
+	it.close()
+}
+
+func (it *Iterator) close() {
 	C.leveldb_iter_destroy(it.it)
 	it.it = nil
+
+	it.db.untrackHandle(it.handle)
+	it.db.release()
+}
+
+//an iterator becomes invalid on errors too, like corruption,
+//check Error to tell them from reaching the end
+func (it *Iterator) Error() error {
+	if it.impl != nil {
+		return it.impl.Error()
+	} else if it.it == nil {
+		return nil
+	}
+
+	var errStr *C.char
+	C.leveldb_iter_get_error(it.it, &errStr)
+	runtime.KeepAlive(it)
+	return saveError(it.db.cfg.Path, errStr)
 }
 
 func (it *Iterator) Valid() bool {
-	return ucharToBool(C.leveldb_iter_valid(it.it))
+	if it.impl != nil {
+		return it.impl.Valid()
+	}
+
+	valid := ucharToBool(C.leveldb_iter_valid(it.it))
+	runtime.KeepAlive(it)
+	return valid
 }
 
 func (it *Iterator) Next() {
+	if it.impl != nil {
+		it.impl.Next()
+		return
+	}
+
 	C.leveldb_iter_next(it.it)
+	runtime.KeepAlive(it)
 }
 
 func (it *Iterator) Prev() {
+	if it.impl != nil {
+		it.impl.Prev()
+		return
+	}
+
 	C.leveldb_iter_prev(it.it)
+	runtime.KeepAlive(it)
 }
 
 func (it *Iterator) SeekToFirst() {
+	if it.impl != nil {
+		it.impl.SeekToFirst()
+		return
+	}
+
 	C.leveldb_iter_seek_to_first(it.it)
+	runtime.KeepAlive(it)
 }
 
 func (it *Iterator) SeekToLast() {
+	if it.impl != nil {
+		it.impl.SeekToLast()
+		return
+	}
+
 	C.leveldb_iter_seek_to_last(it.it)
+	runtime.KeepAlive(it)
 }
 
 func (it *Iterator) Seek(key []byte) {
-	C.leveldb_iter_seek(it.it, (*C.char)(unsafe.Pointer(&key[0])), C.size_t(len(key)))
+	if it.impl != nil {
+		it.impl.Seek(key)
+		return
+	}
+
+	C.leveldb_iter_seek(it.it, bytesPtr(key), C.size_t(len(key)))
+	runtime.KeepAlive(it)
 }
 
 func (it *Iterator) Find(key []byte) []byte {
 	it.Seek(key)
 	if it.Valid() {
-		var klen C.size_t
-		kdata := C.leveldb_iter_key(it.it, &klen)
-		if kdata == nil {
+		if k := it.RawKey(); k == nil {
 			return nil
-		} else if bytes.Equal(slice(unsafe.Pointer(kdata), int(C.int(klen))), key) {
+		} else if it.cmp.Compare(k, key) == 0 {
 			return it.Value()
 		}
 	}
@@ -118,6 +292,10 @@ type RangeLimitIterator struct {
 
 	//0 for IteratorForward, 1 for IteratorBackward
 	direction uint8
+
+	//1 if the inner iterator ran off the db after the last key in the
+	//iterating direction, -1 before the first, else 0
+	off int
 }
 
 func (it *RangeLimitIterator) Key() []byte {
@@ -128,6 +306,15 @@ func (it *RangeLimitIterator) Value() []byte {
 	return it.it.Value()
 }
 
+//see Iterator.RawKey
+func (it *RangeLimitIterator) RawKey() []byte {
+	return it.it.RawKey()
+}
+
+func (it *RangeLimitIterator) RawValue() []byte {
+	return it.it.RawValue()
+}
+
 func (it *RangeLimitIterator) Valid() bool {
 	if it.l.Offset < 0 {
 		return false
@@ -135,39 +322,126 @@ func (it *RangeLimitIterator) Valid() bool {
 		return false
 	} else if it.l.Count >= 0 && it.step >= it.l.Count {
 		return false
+	} else if it.step < 0 && (it.l.Offset > 0 || it.l.Count >= 0) {
+		//moved back out of the offset and count window
+		return false
 	}
 
-	if it.direction == IteratorForward {
-		if it.r.Max != nil {
-			r := bytes.Compare(it.it.Key(), it.r.Max)
-			if it.r.Type&RangeROpen > 0 {
-				return !(r >= 0)
-			} else {
-				return !(r > 0)
-			}
+	return it.inRange(it.it.RawKey())
+}
+
+func (it *RangeLimitIterator) inRange(key []byte) bool {
+	if it.r.Max != nil {
+		r := it.it.cmp.Compare(key, it.r.Max)
+		if it.r.Type&RangeROpen > 0 && r >= 0 {
+			return false
+		} else if r > 0 {
+			return false
 		}
-	} else {
-		if it.r.Min != nil {
-			r := bytes.Compare(it.it.Key(), it.r.Min)
-			if it.r.Type&RangeLOpen > 0 {
-				return !(r <= 0)
-			} else {
-				return !(r < 0)
-			}
+	}
+
+	if it.r.Min != nil {
+		r := it.it.cmp.Compare(key, it.r.Min)
+		if it.r.Type&RangeLOpen > 0 && r <= 0 {
+			return false
+		} else if r < 0 {
+			return false
 		}
 	}
 
 	return true
 }
 
+func (it *RangeLimitIterator) Error() error {
+	return it.it.Error()
+}
+
 func (it *RangeLimitIterator) Next() {
-	it.step++
+	it.move(1)
+}
 
-	if it.direction == IteratorForward {
+//move back one key against the iterating direction, the iterator is
+//invalid before the first key of the offset and count window, if limited
+func (it *RangeLimitIterator) Prev() {
+	it.move(-1)
+}
+
+//moves one key in the iterating direction for 1, against it for -1,
+//which also brings the inner iterator back after it ran off the db
+func (it *RangeLimitIterator) move(d int) {
+	forward := (it.direction == IteratorForward) == (d > 0)
+
+	if !it.it.Valid() {
+		if it.off != -d {
+			return
+		}
+
+		//back to the first or last key of the db it ran off from
+		if forward {
+			it.it.SeekToFirst()
+		} else {
+			it.it.SeekToLast()
+		}
+	} else if forward {
 		it.it.Next()
 	} else {
 		it.it.Prev()
 	}
+
+	it.off = 0
+	if !it.it.Valid() {
+		it.off = d
+	}
+	it.step += d
+}
+
+//the seek functions reset the limit, Count is then counted from the new
+//position and Offset is not skipped again
+
+//first key of the range in the iterating direction
+func (it *RangeLimitIterator) SeekToFirst() {
+	if it.direction == IteratorForward {
+		it.seekMin()
+	} else {
+		it.seekMax()
+	}
+	it.seeked(it.direction == IteratorForward)
+}
+
+//last key of the range in the iterating direction
+func (it *RangeLimitIterator) SeekToLast() {
+	if it.direction == IteratorForward {
+		it.seekMax()
+	} else {
+		it.seekMin()
+	}
+	it.seeked(it.direction != IteratorForward)
+}
+
+//first key of the range at or after key in the iterating direction,
+//a key outside the range moves to the first or past the last key of it
+func (it *RangeLimitIterator) Seek(key []byte) {
+	if it.direction == IteratorForward {
+		it.seekGE(key)
+	} else {
+		it.seekLE(key)
+	}
+	it.seeked(it.direction == IteratorForward)
+}
+
+//resets the limit after a seek, which moved the inner iterator forward in
+//the key order if up, and records the end of the db it may have run off
+func (it *RangeLimitIterator) seeked(up bool) {
+	it.step = 0
+
+	it.off = 0
+	if !it.it.Valid() {
+		if up == (it.direction == IteratorForward) {
+			it.off = 1
+		} else {
+			it.off = -1
+		}
+	}
 }
 
 func (it *RangeLimitIterator) Close() {
@@ -197,49 +471,88 @@ func rangeLimitIterator(i *Iterator, r *Range, l *Limit, direction uint8) *Range
 		return it
 	}
 
-	if direction == IteratorForward {
-		if r.Min == nil {
-			it.it.SeekToFirst()
-		} else {
-			it.it.Seek(r.Min)
+	it.SeekToFirst()
 
-			if r.Type&RangeLOpen > 0 {
-				if it.it.Valid() && bytes.Equal(it.it.Key(), r.Min) {
-					it.it.Next()
-				}
+	for i := 0; i < l.Offset; i++ {
+		if it.it.Valid() {
+			if it.direction == IteratorForward {
+				it.it.Next()
+			} else {
+				it.it.Prev()
 			}
 		}
+	}
+
+	//ran off while skipping the offset
+	if !it.it.Valid() && it.off == 0 {
+		it.off = 1
+	}
+
+	return it
+}
+
+//smallest key in the range
+func (it *RangeLimitIterator) seekMin() {
+	r := it.r
+	if r.Min == nil {
+		it.it.SeekToFirst()
 	} else {
-		if r.Max == nil {
-			it.it.SeekToLast()
-		} else {
-			it.it.Seek(r.Max)
+		it.it.Seek(r.Min)
 
-			if !it.it.Valid() {
-				it.it.SeekToLast()
-			} else {
-				if !bytes.Equal(it.it.Key(), r.Max) {
-					it.it.Prev()
-				}
+		if r.Type&RangeLOpen > 0 {
+			if it.it.Valid() && it.it.cmp.Compare(it.it.RawKey(), r.Min) == 0 {
+				it.it.Next()
 			}
+		}
+	}
+}
+
+//largest key in the range
+func (it *RangeLimitIterator) seekMax() {
+	r := it.r
+	if r.Max == nil {
+		it.it.SeekToLast()
+	} else {
+		it.it.Seek(r.Max)
 
-			if r.Type&RangeROpen > 0 {
-				if it.it.Valid() && bytes.Equal(it.it.Key(), r.Max) {
-					it.it.Prev()
-				}
+		if !it.it.Valid() {
+			it.it.SeekToLast()
+		} else {
+			if it.it.cmp.Compare(it.it.RawKey(), r.Max) != 0 {
+				it.it.Prev()
 			}
 		}
-	}
 
-	for i := 0; i < l.Offset; i++ {
-		if it.it.Valid() {
-			if it.direction == IteratorForward {
-				it.it.Next()
-			} else {
+		if r.Type&RangeROpen > 0 {
+			if it.it.Valid() && it.it.cmp.Compare(it.it.RawKey(), r.Max) == 0 {
 				it.it.Prev()
 			}
 		}
 	}
+}
+
+//smallest key in the range >= key
+func (it *RangeLimitIterator) seekGE(key []byte) {
+	if it.r.Min != nil && it.it.cmp.Compare(key, it.r.Min) <= 0 {
+		it.seekMin()
+		return
+	}
 
-	return it
+	it.it.Seek(key)
+}
+
+//largest key in the range <= key
+func (it *RangeLimitIterator) seekLE(key []byte) {
+	if it.r.Max != nil && it.it.cmp.Compare(key, it.r.Max) >= 0 {
+		it.seekMax()
+		return
+	}
+
+	it.it.Seek(key)
+
+	if !it.it.Valid() {
+		it.it.SeekToLast()
+	} else if it.it.cmp.Compare(it.it.RawKey(), key) != 0 {
+		it.it.Prev()
+	}
 }
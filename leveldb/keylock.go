@@ -0,0 +1,104 @@
+package leveldb
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+const keyLockStripes = 256
+
+// keyLocks serializes read-modify-write operations on the same keys.
+// Keys are hashed to a fixed set of mutexes, so unrelated keys sharing
+// a stripe wait for each other too.
+type keyLocks struct {
+	stripes [keyLockStripes]sync.Mutex
+}
+
+func (l *keyLocks) stripe(key []byte) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % keyLockStripes)
+}
+
+// lock locks the stripes of keys in ascending order, so callers locking
+// overlapping keys can not deadlock, and returns the function unlocking them.
+func (l *keyLocks) lock(keys ...[]byte) func() {
+	stripes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		stripes = append(stripes, l.stripe(key))
+	}
+	sort.Ints(stripes)
+
+	//each stripe only once
+	n := 0
+	for i, s := range stripes {
+		if i == 0 || s != stripes[n-1] {
+			stripes[n] = s
+			n++
+		}
+	}
+	stripes = stripes[:n]
+
+	for _, s := range stripes {
+		l.stripes[s].Lock()
+	}
+
+	return func() {
+		for i := len(stripes) - 1; i >= 0; i-- {
+			l.stripes[stripes[i]].Unlock()
+		}
+	}
+}
+
+// Update replaces the value of key with what fn returns for the old one,
+// which is nil if key does not exist. If fn returns a nil value key is
+// deleted, if fn returns an error nothing is written and the error is returned.
+//
+// Update, UpdateMany and the other read-modify-write functions of DB lock
+// the keys against each other and Txn commits, but not against plain writes
+// like Put.
+func (db *DB) Update(key []byte, fn func(old []byte) ([]byte, error)) error {
+	return db.UpdateMany([][]byte{key}, func(old [][]byte) ([][]byte, error) {
+		value, err := fn(old[0])
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{value}, nil
+	})
+}
+
+// UpdateMany is Update for several keys, which are read from one snapshot
+// and written in one batch. fn gets the old values in the order of keys and
+// must return as many new values.
+func (db *DB) UpdateMany(keys [][]byte, fn func(old [][]byte) ([][]byte, error)) error {
+	unlock := db.keyLocks.lock(keys...)
+	defer unlock()
+
+	old, _, err := db.MultiGet(keys)
+	if err != nil {
+		return err
+	}
+
+	values, err := fn(old)
+	if err != nil {
+		return err
+	} else if len(values) != len(keys) {
+		return newError(ErrInvalidArgument, db.cfg.Path,
+			fmt.Sprintf("update returned %d values for %d keys", len(values), len(keys)))
+	}
+
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+
+	for i, key := range keys {
+		if values[i] == nil {
+			wb.Delete(key)
+		} else {
+			wb.Put(key, values[i])
+		}
+	}
+
+	return wb.Commit()
+}
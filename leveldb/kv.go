@@ -0,0 +1,39 @@
+package leveldb
+
+// Reader is the read access shared by DB, Snapshot, MemDB and IndexedBatch.
+type Reader interface {
+	Get(key []byte) ([]byte, error)
+	Lookup(key []byte) ([]byte, bool, error)
+	Has(key []byte) (bool, error)
+
+	NewIterator() *Iterator
+
+	RangeIterator(min []byte, max []byte, rangeType uint8) *RangeLimitIterator
+	RevRangeIterator(min []byte, max []byte, rangeType uint8) *RangeLimitIterator
+	RangeLimitIterator(min []byte, max []byte, rangeType uint8, offset int, count int) *RangeLimitIterator
+	RevRangeLimitIterator(min []byte, max []byte, rangeType uint8, offset int, count int) *RangeLimitIterator
+	PrefixIterator(prefix []byte) *RangeLimitIterator
+	RevPrefixIterator(prefix []byte) *RangeLimitIterator
+}
+
+// Writer is the write access shared by DB, WriteBatch, MemDB and IndexedBatch.
+type Writer interface {
+	Put(key, value []byte) error
+	Delete(key []byte) error
+}
+
+// ReadWriter is implemented by DB, MemDB and IndexedBatch.
+type ReadWriter interface {
+	Reader
+	Writer
+}
+
+var (
+	_ ReadWriter = (*DB)(nil)
+	_ ReadWriter = (*MemDB)(nil)
+	_ ReadWriter = (*IndexedBatch)(nil)
+	_ Reader     = (*Snapshot)(nil)
+	_ Writer     = (*WriteBatch)(nil)
+	_ Writer     = (*AutoFlushBatch)(nil)
+	_ Writer     = (*Txn)(nil)
+)
@@ -2,10 +2,17 @@ package leveldb
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"os"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var testConfigJson = []byte(`
@@ -35,6 +42,36 @@ func getTestDB() *DB {
 	return testDB
 }
 
+func newTestConfig(name string) *Config {
+	cfg := new(Config)
+	cfg.Path = "/tmp/testdb_" + name
+	return cfg
+}
+
+// openTestDB opens an empty db at /tmp/testdb_<name>, which is destroyed
+// when the test ends.
+func openTestDB(t testing.TB, name string) *DB {
+	return openTestDBWithConfig(t, newTestConfig(name))
+}
+
+// openTestDBWithConfig is like openTestDB with cfg. Close a cfg.Env with
+// t.Cleanup registered before this call, so it outlives the db.
+func openTestDBWithConfig(t testing.TB, cfg *Config) *DB {
+	if cfg.Env == nil {
+		os.RemoveAll(cfg.Path)
+	}
+
+	db, err := OpenWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Destroy()
+	})
+	return db
+}
+
 func TestSimple(t *testing.T) {
 	db := getTestDB()
 
@@ -216,44 +253,2151 @@ func TestSnapshot(t *testing.T) {
 	}
 }
 
-func TestDestroy(t *testing.T) {
+func TestStats(t *testing.T) {
 	db := getTestDB()
 
-	db.Put([]byte("a"), []byte("1"))
-	if err := db.Clear(); err != nil {
+	db.Put([]byte("stats_key"), []byte("stats_value"))
+
+	if v := db.GetProperty("leveldb.stats"); len(v) == 0 {
+		t.Fatal("empty leveldb.stats")
+	}
+
+	if v := db.GetProperty("leveldb.no-such-property"); len(v) != 0 {
+		t.Fatal(v)
+	}
+
+	s, err := db.Stats()
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	if _, err := os.Stat(db.cfg.Path); err != nil {
-		t.Fatal("must exist ", err.Error())
+	if len(s.Levels) == 0 {
+		t.Fatal("no levels")
 	}
 
-	if v, err := db.Get([]byte("a")); err != nil {
+	for i, l := range s.Levels {
+		if l.Level != i {
+			t.Fatal(l.Level, i)
+		} else if l.Files < 0 || l.Size < 0 {
+			t.Fatal(l)
+		}
+	}
+}
+
+func TestParseStats(t *testing.T) {
+	s := &Stats{Levels: make([]LevelStats, 3)}
+	for i := range s.Levels {
+		s.Levels[i].Level = i
+	}
+
+	sstables := "--- level 0 ---\n" +
+		" 7:100['a' @ 3 : 1 .. 'c' @ 5 : 1]\n" +
+		" 8:200['d' @ 6 : 1 .. 'f' @ 9 : 1]\n" +
+		"--- level 1 ---\n" +
+		"--- level 2 ---\n" +
+		" 4:4096['a' @ 1 : 1 .. 'z' @ 2 : 1]\n"
+
+	if err := s.parseSSTables(sstables); err != nil {
 		t.Fatal(err)
-	} else if string(v) == "1" {
+	}
+
+	stats := "                               Compactions\n" +
+		"Level  Files Size(MB) Time(sec) Read(MB) Write(MB)\n" +
+		"--------------------------------------------------\n" +
+		"  0        2        0         1        0         3\n" +
+		"  2        1        0         2        5         4\n"
+
+	if err := s.parseCompactions(stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Levels[0].Size != 300 || s.Levels[1].Size != 0 || s.Levels[2].Size != 4096 {
+		t.Fatal(s.Levels)
+	}
+
+	if s.Levels[0].CompactionWrite != 3*statsMB || s.Levels[0].CompactionTime != time.Second {
+		t.Fatal(s.Levels[0])
+	}
+
+	if s.Levels[2].CompactionRead != 5*statsMB || s.Levels[2].CompactionTime != 2*time.Second {
+		t.Fatal(s.Levels[2])
+	}
+}
+
+func TestCompactAll(t *testing.T) {
+	db := getTestDB()
+
+	db.Clear()
+
+	for _, k := range []string{"a1", "a2", "b1", "c1", "c2"} {
+		db.Put([]byte(k), []byte("v"))
+	}
+
+	var rs []Range
+	if err := db.CompactAll(context.Background(), func(r Range) {
+		rs = append(rs, r)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	//[nil, b) [b, c) [c, d) [d, nil]
+	if len(rs) != 4 {
+		t.Fatal(len(rs))
+	}
+
+	if rs[0].Min != nil || rs[len(rs)-1].Max != nil {
+		t.Fatal("first and last range must be unbounded")
+	}
+
+	for i := 1; i < len(rs); i++ {
+		if !bytes.Equal(rs[i-1].Max, rs[i].Min) {
+			t.Fatal("ranges not contiguous", rs)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := db.CompactAll(ctx, func(r Range) {
+		t.Fatal("must not compact after cancel")
+	}); err != context.Canceled {
+		t.Fatal(err)
+	}
+
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := db.Get([]byte("b1")); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "v" {
 		t.Fatal(string(v))
 	}
+}
 
-	db.Destroy()
+func TestApproximateSizes(t *testing.T) {
+	db := getTestDB()
 
-	if _, err := os.Stat(db.cfg.Path); !os.IsNotExist(err) {
-		t.Fatal("must not exist")
+	db.Clear()
+
+	value := bytes.Repeat([]byte("v"), 200)
+	for i := 0; i < 2000; i++ {
+		db.Put([]byte(fmt.Sprintf("size_%04d", i)), value)
+	}
+
+	//flush memtable to sstables
+	db.CompactRange(nil, nil)
+
+	sizes, err := db.ApproximateSizes([]Range{
+		{nil, nil, RangeClose},
+		{[]byte("size_"), []byte("size_9999"), RangeClose},
+		{[]byte("zzz"), nil, RangeClose},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(sizes) != 3 {
+		t.Fatal(len(sizes))
+	}
+
+	if sizes[0] == 0 || sizes[1] == 0 {
+		t.Fatal(sizes)
+	} else if sizes[2] > sizes[0]/10 {
+		t.Fatal(sizes)
+	}
+
+	rs, err := db.SplitRange(Range{nil, nil, RangeClose}, 4)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(rs) != 4 {
+		t.Fatal(len(rs))
+	}
+
+	if rs[0].Min != nil || rs[len(rs)-1].Max != nil {
+		t.Fatal("first and last range must be unbounded")
+	}
+
+	total := 0
+	for i, r := range rs {
+		if i > 0 && !bytes.Equal(rs[i-1].Max, r.Min) {
+			t.Fatal("ranges not contiguous")
+		}
+
+		n := 0
+		it := db.RangeIterator(r.Min, r.Max, r.Type)
+		for ; it.Valid(); it.Next() {
+			n++
+		}
+		it.Close()
+
+		if n < 2000/10 || n > 2000*4/10 {
+			t.Fatal(i, n)
+		}
+		total += n
+	}
+
+	if total != 2000 {
+		t.Fatal(total)
 	}
 }
 
-func TestCloseMore(t *testing.T) {
-	cfg := new(Config)
-	cfg.Path = "/tmp/testdb1234"
-	cfg.CacheSize = 4 * 1024 * 1024
-	os.RemoveAll(cfg.Path)
+type reverseComparator struct {
+	successors int32
+}
+
+func (c *reverseComparator) Compare(a, b []byte) int {
+	return -bytes.Compare(a, b)
+}
+
+func (c *reverseComparator) Name() string {
+	return "test.ReverseComparator"
+}
+
+func (c *reverseComparator) FindShortestSeparator(start, limit []byte) []byte {
+	return start
+}
+
+func (c *reverseComparator) FindShortSuccessor(key []byte) []byte {
+	atomic.AddInt32(&c.successors, 1)
+	return key
+}
+
+func TestComparator(t *testing.T) {
+	cmp := new(reverseComparator)
+	RegisterComparator(cmp)
+
+	cfg := newTestConfig("comparator")
+	cfg.Comparator = cmp.Name()
+	db := openTestDBWithConfig(t, cfg)
+
+	for i := 0; i < 10; i++ {
+		db.Put([]byte(fmt.Sprintf("key_%d", i)), []byte("v"))
+	}
+
+	it := db.NewIterator()
+	it.SeekToFirst()
+	if !it.Valid() || string(it.Key()) != "key_9" {
+		t.Fatal("must start with the greatest key")
+	}
+	it.Close()
+
+	k := func(i int) []byte {
+		return []byte(fmt.Sprintf("key_%d", i))
+	}
+
+	if err := checkIterator(db.RangeIterator(k(5), k(1), RangeClose), 5, 4, 3, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkIterator(db.RangeLimitIterator(k(5), k(1), RangeOpen, 1, 2), 3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkIterator(db.RevRangeIterator(k(5), k(1), RangeLOpen), 1, 2, 3, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	db.CompactRange(nil, nil)
+	if atomic.LoadInt32(&cmp.successors) == 0 {
+		t.Fatal("FindShortSuccessor not called")
+	}
+
+	sizes, err := db.ApproximateSizes([]Range{
+		{nil, nil, RangeClose},
+		{k(5), k(1), RangeClose},
+		{k(1), k(5), RangeClose},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if sizes[0] == 0 || sizes[1] == 0 || sizes[1] >= sizes[0] {
+		t.Fatal(sizes)
+	} else if sizes[2] != 0 {
+		t.Fatal(sizes)
+	}
+
+	db.Close()
+
+	cfg.Comparator = ""
+	if db, err := OpenWithConfig(cfg); err == nil {
+		db.Close()
+		t.Fatal("must fail with a different comparator")
+	}
+
+	cfg.Comparator = "test.NotRegistered"
+	if _, err := OpenWithConfig(cfg); err == nil {
+		t.Fatal("must fail with an unregistered comparator")
+	} else if err = Repair(cfg); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal(err)
+	}
+}
+
+func TestBytewiseComparator(t *testing.T) {
+	cmp := BytewiseComparator
+
+	if s := cmp.FindShortestSeparator([]byte("abcdef"), []byte("abzz")); string(s) != "abd" {
+		t.Fatal(string(s))
+	}
+
+	if s := cmp.FindShortestSeparator([]byte("abc"), []byte("abcd")); string(s) != "abc" {
+		t.Fatal(string(s))
+	}
+
+	if s := cmp.FindShortSuccessor([]byte("\xff\xffabc")); string(s) != "\xff\xffb" {
+		t.Fatal(string(s))
+	}
+
+	if s := cmp.FindShortSuccessor([]byte("\xff\xff")); string(s) != "\xff\xff" {
+		t.Fatal(string(s))
+	}
+}
+
+type countFilter struct {
+	Filter
+
+	creates int32
+	matches int32
+}
+
+func (f *countFilter) Name() string {
+	return "test.CountFilter"
+}
+
+func (f *countFilter) CreateFilter(keys [][]byte) []byte {
+	atomic.AddInt32(&f.creates, 1)
+	return f.Filter.CreateFilter(keys)
+}
+
+func (f *countFilter) KeyMayMatch(key []byte, filter []byte) bool {
+	atomic.AddInt32(&f.matches, 1)
+	return f.Filter.KeyMayMatch(key, filter)
+}
+
+func TestFilterPolicy(t *testing.T) {
+	f := &countFilter{Filter: NewPrefixBloomFilter(4, 10)}
+	RegisterFilter(f)
+
+	cfg := newTestConfig("filter")
+	cfg.FilterPolicy = f.Name()
+	db := openTestDBWithConfig(t, cfg)
+
 	for i := 0; i < 100; i++ {
-		db, err := OpenWithConfig(cfg)
-		if err != nil {
+		db.Put([]byte(fmt.Sprintf("user:%d", i)), []byte("v"))
+	}
+
+	db.CompactRange(nil, nil)
+
+	if atomic.LoadInt32(&f.creates) == 0 {
+		t.Fatal("CreateFilter not called")
+	}
+
+	for i := 0; i < 100; i++ {
+		if v, err := db.Get([]byte(fmt.Sprintf("user:%d", i))); err != nil {
 			t.Fatal(err)
+		} else if string(v) != "v" {
+			t.Fatal(i, string(v))
 		}
+	}
 
-		db.Put([]byte("key"), []byte("value"))
+	if v, err := db.Get([]byte("item:1")); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatal(string(v))
+	}
 
-		db.Close()
+	if atomic.LoadInt32(&f.matches) == 0 {
+		t.Fatal("KeyMayMatch not called")
+	}
+
+	cfg.FilterPolicy = "test.NotRegistered"
+	if _, err := OpenWithConfig(cfg); err == nil {
+		t.Fatal("must fail with an unregistered filter policy")
+	}
+
+	cfg.FilterPolicy = PrefixBloomFilterPolicy
+	if _, err := OpenWithConfig(cfg); err == nil {
+		t.Fatal("must fail without filter prefix length")
+	}
+}
+
+func TestPrefixBloomFilter(t *testing.T) {
+	f := NewPrefixBloomFilter(4, 10)
+
+	var keys [][]byte
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("%04d:suffix", i)))
+	}
+
+	filter := f.CreateFilter(keys)
+
+	for i := 0; i < 1000; i++ {
+		if !f.KeyMayMatch([]byte(fmt.Sprintf("%04d:other", i)), filter) {
+			t.Fatal("prefix must match", i)
+		}
+	}
+
+	n := 0
+	for i := 0x1000; i < 0x1000+10000; i++ {
+		if f.KeyMayMatch([]byte(fmt.Sprintf("%04x:suffix", i)), filter) {
+			n++
+		}
+	}
+
+	//about 1% with 10 bits per key
+	if n > 10000*5/100 {
+		t.Fatal("false positive rate too high", n)
+	}
+
+	if f.KeyMayMatch([]byte("abc"), nil) {
+		t.Fatal("empty filter must not match")
+	}
+
+	//vectors from leveldb's hash_test.cc
+	for _, c := range []struct {
+		data []byte
+		hash uint32
+	}{
+		{[]byte{}, 0xbc9f1d34},
+		{[]byte{0x62}, 0xef1345c4},
+		{[]byte{0xc3, 0x97}, 0x5b663814},
+		{[]byte{0xe2, 0x99, 0xa5}, 0x323c078f},
+		{[]byte{0xe1, 0x80, 0xb9, 0x32}, 0xed21633a},
+	} {
+		if h := bloomHash(c.data); h != c.hash {
+			t.Fatalf("hash %x != %x", h, c.hash)
+		}
+	}
+}
+
+type testLogger struct {
+	sync.Mutex
+	msgs   []string
+	levels []LogLevel
+}
+
+func (l *testLogger) Log(level LogLevel, msg string) {
+	l.Lock()
+	l.msgs = append(l.msgs, msg)
+	l.levels = append(l.levels, level)
+	l.Unlock()
+}
+
+func TestLogger(t *testing.T) {
+	l := new(testLogger)
+
+	cfg := newTestConfig("logger")
+	cfg.Logger = l
+	db := openTestDBWithConfig(t, cfg)
+
+	db.Put([]byte("key"), []byte("value"))
+	db.CompactRange(nil, nil)
+	db.Close()
+
+	l.Lock()
+	defer l.Unlock()
+
+	if len(l.msgs) == 0 {
+		t.Fatal("no log received")
+	}
+
+	for _, msg := range l.msgs {
+		if len(msg) == 0 || strings.HasSuffix(msg, "\n") {
+			t.Fatalf("bad message %q", msg)
+		}
+	}
+
+	if _, err := os.Stat(cfg.Path + "/LOG"); err == nil {
+		t.Fatal("LOG file must not be written")
+	}
+}
+
+func TestInferLogLevel(t *testing.T) {
+	for msg, level := range map[string]LogLevel{
+		"Recovering log #3":                           LogInfo,
+		"Compacting 1@0 + 0@1 files":                  LogInfo,
+		"Delete type=0 #5":                            LogDebug,
+		"Compaction error: IO error: disk full":       LogError,
+		"Ignoring error IO error: 000005.ldb missing": LogWarn,
+		"log #7: dropping 32 bytes; Corruption: bad":  LogWarn,
+		"Corruption: checksum mismatch":               LogError,
+	} {
+		if l := inferLogLevel(msg); l != level {
+			t.Fatalf("%q: %s != %s", msg, l, level)
+		}
+	}
+}
+
+func TestMemEnv(t *testing.T) {
+	env := NewMemEnv()
+	t.Cleanup(env.Close)
+
+	cfg := newTestConfig("memenv")
+	cfg.Env = env
+	db := openTestDBWithConfig(t, cfg)
+
+	db.Put([]byte("key"), []byte("value"))
+	db.Close()
+
+	if _, err := os.Stat(cfg.Path); !os.IsNotExist(err) {
+		t.Fatal("must not touch disk")
+	}
+
+	db, err := OpenWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := db.Get([]byte("key")); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "value" {
+		t.Fatal(string(v))
+	}
+
+	if err := db.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHookedEnv(t *testing.T) {
+	env := NewMemEnv()
+	t.Cleanup(env.Close)
+
+	var writes, written int64
+	hooked := NewHookedEnv(env, func(op FileOp, name string, n int) {
+		if op == FileWrite {
+			atomic.AddInt64(&writes, 1)
+			atomic.AddInt64(&written, int64(n))
+		}
+	})
+	t.Cleanup(hooked.Close)
+
+	cfg := newTestConfig("hookedenv")
+	cfg.Env = hooked
+	db := openTestDBWithConfig(t, cfg)
+
+	if err := db.SyncPut([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt64(&writes) == 0 || atomic.LoadInt64(&written) < int64(len("keyvalue")) {
+		t.Fatal("file writes not hooked")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	cfg := newTestConfig("errors")
+	db := openTestDBWithConfig(t, cfg)
+
+	var e *Error
+	if _, err := OpenWithConfig(cfg); !errors.Is(err, ErrLockHeld) || !errors.Is(err, ErrIO) {
+		t.Fatal(err)
+	} else if !errors.As(err, &e) || e.Path != cfg.Path {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(e.Msg, "IO error: ") {
+		t.Fatal(e.Msg)
+	}
+
+	//a failed open releases the handle to its logger
+	var released atomic.Bool
+	func() {
+		l := new(testLogger)
+		runtime.SetFinalizer(l, func(*testLogger) { released.Store(true) })
+
+		lcfg := *cfg
+		lcfg.Logger = l
+		if _, err := OpenWithConfig(&lcfg); !errors.Is(err, ErrLockHeld) {
+			t.Fatal(err)
+		}
+	}()
+
+	for i := 0; !released.Load(); i++ {
+		if i == 100 {
+			t.Fatal("logger is not released")
+		}
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+
+	db.Close()
+
+	if err := db.Put([]byte("key"), []byte("value")); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Get([]byte("key")); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	}
+
+	if _, err := db.ApproximateSizes([]Range{{nil, nil, RangeClose}}); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	} else if _, err = db.SplitRange(Range{nil, nil, RangeClose}, 2); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	}
+
+	cfg.Comparator = "test.NotRegistered"
+	if _, err := OpenWithConfig(cfg); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal(err)
+	}
+
+	for msg, kind := range map[string]error{
+		"NotFound: 000005.ldb":                       ErrNotFound,
+		"Corruption: bad block type":                 ErrCorruption,
+		"Not implemented: compression":               ErrNotSupported,
+		"Invalid argument: /tmp/db: does not exist":  ErrInvalidArgument,
+		"IO error: /tmp/db/000005.ldb: No such file": ErrIO,
+		"IO error: lock /tmp/db/LOCK: already held":  ErrLockHeld,
+		"IO error: lock /tmp/db/LOCK: Resource busy": ErrLockHeld,
+		"something leveldb does not usually produce": ErrIO,
+	} {
+		if k := classifyError(msg); k != kind {
+			t.Fatalf("%q: %v != %v", msg, k, kind)
+		}
+	}
+}
+
+func TestEmptyValue(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("empty_value_key")
+	missing := []byte("empty_value_missing")
+	db.Delete(missing)
+
+	if err := db.Put(key, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := db.Get(key); err != nil {
+		t.Fatal(err)
+	} else if v == nil || len(v) != 0 {
+		t.Fatal("must non-nil empty value")
+	}
+
+	if v, found, err := db.Lookup(key); err != nil {
+		t.Fatal(err)
+	} else if !found || v == nil || len(v) != 0 {
+		t.Fatal("must found empty value")
+	}
+
+	if v, found, err := db.Lookup(missing); err != nil {
+		t.Fatal(err)
+	} else if found || v != nil {
+		t.Fatal("must not found")
+	}
+
+	if ok, err := db.Has(key); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("must has key")
+	}
+
+	if ok, err := db.Has(missing); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("must not has key")
+	}
+
+	s := db.NewSnapshot()
+	defer s.Close()
+
+	db.Delete(key)
+
+	if v, found, err := s.Lookup(key); err != nil {
+		t.Fatal(err)
+	} else if !found || v == nil || len(v) != 0 {
+		t.Fatal("must found empty value in snapshot")
+	}
+
+	if ok, err := s.Has(missing); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("must not has key in snapshot")
+	}
+
+	if ok, err := db.Has(key); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("must deleted")
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	db := getTestDB()
+
+	keys := [][]byte{[]byte("mget_a"), []byte("mget_missing"), []byte("mget_empty"), []byte("mget_b")}
+	db.Put(keys[0], []byte("1"))
+	db.Delete(keys[1])
+	db.Put(keys[2], nil)
+	db.Put(keys[3], []byte("22"))
+
+	s := db.NewSnapshot()
+	defer s.Close()
+
+	db.Put(keys[0], []byte("333"))
+
+	check := func(values [][]byte, found []bool, err error, first string) {
+		if err != nil {
+			t.Fatal(err)
+		} else if len(values) != 4 || len(found) != 4 {
+			t.Fatal(len(values), len(found))
+		}
+
+		if !found[0] || string(values[0]) != first {
+			t.Fatal(string(values[0]))
+		} else if found[1] || values[1] != nil {
+			t.Fatal("must not found")
+		} else if !found[2] || values[2] == nil || len(values[2]) != 0 {
+			t.Fatal("must found empty value")
+		} else if !found[3] || string(values[3]) != "22" {
+			t.Fatal(string(values[3]))
+		}
+
+		//values must not overlap
+		values[0] = append(values[0], 'x')
+		if string(values[3]) != "22" {
+			t.Fatal(string(values[3]))
+		}
+	}
+
+	values, found, err := db.MultiGet(keys)
+	check(values, found, err, "333")
+
+	values, found, err = s.MultiGet(keys)
+	check(values, found, err, "1")
+
+	if values, found, err = db.MultiGet(nil); err != nil || values != nil || found != nil {
+		t.Fatal("must empty", err)
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("get_into_key")
+	db.Put(key, []byte("hello"))
+	db.Delete([]byte("get_into_missing"))
+
+	dst := make([]byte, 0, 16)
+	v, err := db.GetInto(key, dst)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(v) != "hello" {
+		t.Fatal(string(v))
+	} else if &v[0] != &dst[:1][0] {
+		t.Fatal("must reuse dst")
+	}
+
+	if v, err = db.GetInto(key, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "hello" {
+		t.Fatal(string(v))
+	}
+
+	if v, err = db.GetInto([]byte("get_into_missing"), dst); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatal("must nil")
+	}
+
+	s := db.NewSnapshot()
+	defer s.Close()
+
+	db.Put(key, nil)
+
+	if v, err = db.GetInto(key, nil); err != nil {
+		t.Fatal(err)
+	} else if v == nil || len(v) != 0 {
+		t.Fatal("must non-nil empty value")
+	}
+
+	if v, err = s.GetInto(key, dst); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "hello" {
+		t.Fatal(string(v))
+	}
+}
+
+func TestRawKeyValue(t *testing.T) {
+	db := getTestDB()
+
+	for i := 0; i < 100; i++ {
+		db.Put([]byte(fmt.Sprintf("raw_%03d", i)), []byte(fmt.Sprintf("value_%d", i)))
+	}
+
+	//every goroutine has its own iterator and buffers
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			it := db.RangeIterator([]byte("raw_"), []byte("raw_999"), RangeClose)
+			defer it.Close()
+
+			var keys [][]byte
+			buf := make([]byte, 0, 16)
+			for ; it.Valid(); it.Next() {
+				if !bytes.Equal(it.RawKey(), it.Key()) || !bytes.Equal(it.RawValue(), it.Value()) {
+					errs <- fmt.Errorf("raw %q != %q", it.RawKey(), it.Key())
+					return
+				}
+
+				//retained raw keys must be copied
+				keys = append(keys, append([]byte{}, it.RawKey()...))
+
+				v, err := db.GetInto(it.RawKey(), buf)
+				if err != nil {
+					errs <- err
+					return
+				} else if !bytes.Equal(v, it.RawValue()) {
+					errs <- fmt.Errorf("%q != %q", v, it.RawValue())
+					return
+				}
+			}
+
+			if len(keys) != 100 || string(keys[99]) != "raw_099" {
+				errs <- fmt.Errorf("got %d keys", len(keys))
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestEmptyKey(t *testing.T) {
+	db := openTestDB(t, "empty_key")
+
+	if err := db.Put([]byte{}, []byte("empty")); err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("a"), []byte("1"))
+
+	if v, err := db.Get(nil); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "empty" {
+		t.Fatal(string(v))
+	}
+
+	it := db.NewIterator()
+	it.Seek(nil)
+	if !it.Valid() || len(it.Key()) != 0 {
+		t.Fatal("must seek to empty key")
+	} else if v := it.Find([]byte{}); string(v) != "empty" {
+		t.Fatal(string(v))
+	} else if err := it.Error(); err != nil {
+		t.Fatal(err)
+	}
+	it.Close()
+
+	if err := db.CompactRange([]byte{}, []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	wb := db.NewWriteBatch()
+	wb.Delete([]byte{})
+	wb.Put([]byte("b"), nil)
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	wb.Close()
+
+	if ok, err := db.Has(nil); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("must deleted")
+	}
+
+	rit := db.RangeIterator(nil, nil, RangeClose)
+	n := 0
+	for ; rit.Valid(); rit.Next() {
+		n++
+	}
+	if err := rit.Error(); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatal(n)
+	}
+	rit.Close()
+}
+
+func TestPrefixIterator(t *testing.T) {
+	db := openTestDB(t, "prefix_iterator")
+
+	for _, k := range []string{"o\xff", "p", "p\x00", "pa", "p\xff", "p\xff\xff", "q", "\xff", "\xff\xff", "\xff\xff\x01"} {
+		db.Put([]byte(k), []byte(k))
+	}
+
+	keys := func(it *RangeLimitIterator) string {
+		defer it.Close()
+
+		var ks []string
+		for ; it.Valid(); it.Next() {
+			ks = append(ks, fmt.Sprintf("%x", it.Key()))
+		}
+		return strings.Join(ks, " ")
+	}
+
+	s := db.NewSnapshot()
+	defer s.Close()
+	db.Delete([]byte("pa"))
+
+	for _, c := range []struct {
+		it   *RangeLimitIterator
+		want string
+	}{
+		{db.PrefixIterator([]byte("p")), "70 7000 70ff 70ffff"},
+		{db.RevPrefixIterator([]byte("p")), "70ffff 70ff 7000 70"},
+		{s.PrefixIterator([]byte("p")), "70 7000 7061 70ff 70ffff"},
+		{s.RevPrefixIterator([]byte("p")), "70ffff 70ff 7061 7000 70"},
+		{db.PrefixIterator([]byte("p\xff")), "70ff 70ffff"},
+		{db.PrefixIterator([]byte("\xff\xff")), "ffff ffff01"},
+		{db.RevPrefixIterator([]byte("\xff")), "ffff01 ffff ff"},
+		{db.PrefixIterator([]byte("x")), ""},
+		{db.RevPrefixIterator(nil), "ffff01 ffff ff 71 70ffff 70ff 7000 70 6fff"},
+	} {
+		if got := keys(c.it); got != c.want {
+			t.Fatalf("%q != %q", got, c.want)
+		}
+	}
+
+	if s := prefixSuccessor([]byte("a\xff\xff")); string(s) != "b" {
+		t.Fatalf("%q", s)
+	} else if s = prefixSuccessor([]byte("\xff\xff")); s != nil {
+		t.Fatalf("%q", s)
+	}
+}
+
+func TestRangeLimitIteratorSeek(t *testing.T) {
+	db := openTestDB(t, "range_seek")
+
+	for i := 0; i < 10; i++ {
+		db.Put([]byte{byte('0' + i)}, nil)
+	}
+
+	key := func(it *RangeLimitIterator) string {
+		if !it.Valid() {
+			return ""
+		}
+		return string(it.Key())
+	}
+
+	//(2, 7]
+	it := db.RangeIterator([]byte("2"), []byte("7"), RangeLOpen)
+	defer it.Close()
+
+	for _, c := range []struct {
+		op   func()
+		want string
+	}{
+		{func() {}, "3"},
+		{it.Next, "4"},
+		{it.Prev, "3"},
+		{it.Prev, ""},
+		{it.Next, "3"},
+		{it.SeekToLast, "7"},
+		{it.Next, ""},
+		{it.Prev, "7"},
+		{it.SeekToFirst, "3"},
+		{func() { it.Seek([]byte("5")) }, "5"},
+		{func() { it.Seek([]byte("45")) }, "5"},
+		{func() { it.Seek([]byte("0")) }, "3"},
+		{func() { it.Seek([]byte("8")) }, ""},
+	} {
+		c.op()
+		if k := key(it); k != c.want {
+			t.Fatalf("%q != %q", k, c.want)
+		}
+	}
+
+	//[2, 7)
+	rit := db.RevRangeIterator([]byte("2"), []byte("7"), RangeROpen)
+	defer rit.Close()
+
+	for _, c := range []struct {
+		op   func()
+		want string
+	}{
+		{func() {}, "6"},
+		{rit.Next, "5"},
+		{rit.Prev, "6"},
+		{rit.Prev, ""},
+		{rit.SeekToLast, "2"},
+		{rit.Next, ""},
+		{rit.Prev, "2"},
+		{rit.SeekToFirst, "6"},
+		{func() { rit.Seek([]byte("45")) }, "4"},
+		{func() { rit.Seek([]byte("9")) }, "6"},
+		{func() { rit.Seek([]byte("1")) }, ""},
+	} {
+		c.op()
+		if k := key(rit); k != c.want {
+			t.Fatalf("%q != %q", k, c.want)
+		}
+	}
+
+	//the whole db, walk off both ends
+	ait := db.RangeIterator(nil, nil, RangeClose)
+	defer ait.Close()
+
+	ait.SeekToLast()
+	ait.Next()
+	ait.Next()
+	ait.SeekToFirst()
+	ait.Prev()
+	ait.Prev()
+	if ait.Valid() {
+		t.Fatal("must invalid")
+	} else if ait.Next(); key(ait) != "0" {
+		t.Fatal(key(ait))
+	}
+
+	//ranges reaching the ends of the db come back from them
+	eit := db.RangeIterator([]byte("8"), nil, RangeClose)
+	defer eit.Close()
+
+	bit := db.RevRangeIterator(nil, []byte("1"), RangeClose)
+	defer bit.Close()
+
+	//Prev does not leave the offset and count window
+	lit := db.RangeLimitIterator(nil, nil, RangeClose, 2, 2)
+	defer lit.Close()
+
+	rlit := db.RevRangeLimitIterator(nil, nil, RangeClose, 2, 2)
+	defer rlit.Close()
+
+	for _, c := range []struct {
+		it   *RangeLimitIterator
+		op   func()
+		want string
+	}{
+		{eit, eit.Next, "9"},
+		{eit, eit.Next, ""},
+		{eit, eit.Next, ""},
+		{eit, eit.Prev, "9"},
+		{eit, eit.Prev, "8"},
+		{eit, eit.Prev, ""},
+		{eit, eit.Next, "8"},
+		{bit, bit.Next, "0"},
+		{bit, bit.Next, ""},
+		{bit, bit.Prev, "0"},
+		{bit, bit.Prev, "1"},
+		{ait, func() { ait.Seek([]byte("a")) }, ""},
+		{ait, ait.Prev, "9"},
+		{ait, func() { ait.Seek([]byte("5")) }, "5"},
+		{ait, ait.Prev, "4"},
+		{bit, func() { bit.Seek([]byte("\x00")) }, ""},
+		{bit, bit.Prev, "0"},
+		{lit, func() {}, "2"},
+		{lit, lit.Next, "3"},
+		{lit, lit.Next, ""},
+		{lit, lit.Prev, "3"},
+		{lit, lit.Prev, "2"},
+		{lit, lit.Prev, ""},
+		{lit, lit.Next, "2"},
+		{rlit, func() {}, "7"},
+		{rlit, rlit.Prev, ""},
+		{rlit, rlit.Next, "7"},
+		{rlit, rlit.Next, "6"},
+		{rlit, rlit.Next, ""},
+	} {
+		c.op()
+		if k := key(c.it); k != c.want {
+			t.Fatalf("%q != %q", k, c.want)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	db := openTestDB(t, "cursor")
+
+	for i := 0; i < 20; i++ {
+		db.Put([]byte(fmt.Sprintf("key_%02d", i)), nil)
+	}
+
+	page := func(it *RangeLimitIterator) (string, string) {
+		defer it.Close()
+
+		var ks []string
+		for ; it.Valid(); it.Next() {
+			ks = append(ks, string(it.Key()[4:]))
+		}
+		return strings.Join(ks, ","), it.Cursor()
+	}
+
+	s := db.NewSnapshot()
+	defer s.Close()
+	db.Delete([]byte("key_11"))
+
+	keys, cur := page(db.RangeLimitIterator([]byte("key_05"), []byte("key_15"), RangeOpen, 0, 4))
+	var pages []string
+	for cur != "" {
+		pages = append(pages, keys)
+
+		it, err := db.ResumeRangeLimitIterator(cur, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, cur = page(it)
+	}
+	pages = append(pages, keys)
+
+	if p := strings.Join(pages, "|"); p != "06,07,08,09|10,12,13,14" {
+		t.Fatal(p)
+	}
+
+	keys, cur = page(s.RevRangeLimitIterator(nil, []byte("key_12"), RangeClose, 0, 3))
+	if keys != "12,11,10" {
+		t.Fatal(keys)
+	}
+
+	it, err := s.ResumeRangeLimitIterator(cur, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, cur = page(it); keys != "09,08" {
+		t.Fatal(keys)
+	}
+
+	it, err = s.ResumeRangeLimitIterator(cur, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, cur = page(it); keys != "07,06,05,04,03,02,01,00" || cur != "" {
+		t.Fatal(keys, cur)
+	}
+
+	for _, token := range []string{"", "!!", "AQ", cur + "AAAA"} {
+		if _, err := db.ResumeRangeLimitIterator(token, 1); !errors.Is(err, ErrInvalidArgument) {
+			t.Fatal(token, err)
+		}
+	}
+}
+
+func TestMemDB(t *testing.T) {
+	env := NewMemEnv()
+	t.Cleanup(env.Close)
+
+	cfg := newTestConfig("memdb")
+	cfg.Env = env
+	db := openTestDBWithConfig(t, cfg)
+
+	m := NewMemDB(nil)
+
+	//the same writes to both, half of those to db through a batch
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+	for i, dbw := range []Writer{db, wb} {
+		for j := 0; j < 200; j++ {
+			k := []byte(fmt.Sprintf("k%02d", (i*131+j*17)%60))
+			for _, w := range []Writer{dbw, m} {
+				if j%5 == 0 {
+					w.Delete(k)
+				} else if j%7 == 0 {
+					w.Put(k, nil)
+				} else {
+					w.Put(k, []byte(fmt.Sprintf("%d_%d", i, j)))
+				}
+			}
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := func(r Reader) string {
+		var s []string
+		for _, it := range []*RangeLimitIterator{
+			r.RangeIterator(nil, nil, RangeClose),
+			r.RevRangeLimitIterator([]byte("k10"), []byte("k40"), RangeLOpen, 2, 5),
+			r.PrefixIterator([]byte("k3")),
+			r.RevPrefixIterator([]byte("k5")),
+		} {
+			for ; it.Valid(); it.Next() {
+				s = append(s, fmt.Sprintf("%s=%q", it.Key(), it.Value()))
+			}
+			it.Close()
+			s = append(s, "|")
+		}
+
+		for i := 0; i < 60; i++ {
+			k := []byte(fmt.Sprintf("k%02d", i))
+			v, found, err := r.Lookup(k)
+			has, _ := r.Has(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s = append(s, fmt.Sprintf("%q %v %v %v", v, v == nil, found, has))
+		}
+		return strings.Join(s, " ")
+	}
+
+	if a, b := dump(db), dump(m); a != b {
+		t.Fatalf("%s\n!=\n%s", a, b)
+	}
+
+	snap := db.NewSnapshot()
+	defer snap.Close()
+
+	it := m.NewIterator()
+	defer it.Close()
+
+	db.Put([]byte("k00"), []byte("new"))
+	m.Put([]byte("k00"), []byte("new"))
+
+	//m's iterator keeps the data as of its creation, like a snapshot
+	it.Seek([]byte("k00"))
+	if v, _ := snap.Get([]byte("k00")); it.Valid() && !bytes.Equal(it.Value(), v) {
+		t.Fatalf("%q != %q", it.Value(), v)
+	}
+
+	wb.Close()
+	if err := wb.Put([]byte("k"), nil); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	}
+}
+
+func TestIndexedBatch(t *testing.T) {
+	env := NewMemEnv()
+	t.Cleanup(env.Close)
+
+	cfg := newTestConfig("indexed_batch")
+	cfg.Env = env
+	db := openTestDBWithConfig(t, cfg)
+
+	//model is what reading from the batch must look like
+	model := NewMemDB(nil)
+	rnd := rand.New(rand.NewSource(1))
+	key := func() []byte {
+		return []byte(fmt.Sprintf("k%02d", rnd.Intn(50)))
+	}
+
+	for i := 0; i < 30; i++ {
+		k := key()
+		db.Put(k, k)
+		model.Put(k, k)
+	}
+
+	s := db.NewSnapshot()
+	defer s.Close()
+	db.Put([]byte("k99"), nil)
+
+	b := s.NewIndexedBatch()
+	defer b.Close()
+
+	for i := 0; i < 40; i++ {
+		k := key()
+		if i%3 == 0 {
+			b.Delete(k)
+			model.Delete(k)
+		} else {
+			v := []byte(fmt.Sprintf("v%d", i))
+			b.Put(k, v)
+			model.Put(k, v)
+		}
+	}
+
+	it := b.NewIterator()
+	defer it.Close()
+	mit := model.NewIterator()
+	defer mit.Close()
+
+	//later writes are not seen by it
+	b.Put([]byte("k00"), []byte("later"))
+
+	pos := func(it *Iterator) string {
+		if !it.Valid() {
+			return "invalid"
+		}
+		return fmt.Sprintf("%s=%s", it.Key(), it.Value())
+	}
+
+	for i := 0; i < 2000; i++ {
+		var op string
+		switch n := rnd.Intn(10); {
+		case n < 4 && it.Valid():
+			op = "next"
+			it.Next()
+			mit.Next()
+		case n < 8 && it.Valid():
+			op = "prev"
+			it.Prev()
+			mit.Prev()
+		case n == 8:
+			k := key()
+			op = "seek " + string(k)
+			it.Seek(k)
+			mit.Seek(k)
+		default:
+			if rnd.Intn(2) == 0 {
+				op = "first"
+				it.SeekToFirst()
+				mit.SeekToFirst()
+			} else {
+				op = "last"
+				it.SeekToLast()
+				mit.SeekToLast()
+			}
+		}
+
+		if a, b := pos(it), pos(mit); a != b {
+			t.Fatalf("%d %s: %s != %s", i, op, a, b)
+		}
+	}
+
+	model.Put([]byte("k00"), []byte("later"))
+	for i := 0; i < 50; i++ {
+		k := []byte(fmt.Sprintf("k%02d", i))
+		v, found, err := b.Lookup(k)
+		mv, mfound, _ := model.Lookup(k)
+		if err != nil {
+			t.Fatal(err)
+		} else if found != mfound || !bytes.Equal(v, mv) {
+			t.Fatalf("%s: %q %v != %q %v", k, v, found, mv, mfound)
+		}
+	}
+
+	var keys []string
+	rit := b.RevPrefixIterator([]byte("k0"))
+	for ; rit.Valid(); rit.Next() {
+		keys = append(keys, string(rit.Key()))
+	}
+	rit.Close()
+
+	var mkeys []string
+	rit = model.RevPrefixIterator([]byte("k0"))
+	for ; rit.Valid(); rit.Next() {
+		mkeys = append(mkeys, string(rit.Key()))
+	}
+	rit.Close()
+
+	if a, b := strings.Join(keys, " "), strings.Join(mkeys, " "); a != b {
+		t.Fatalf("%s != %s", a, b)
+	}
+
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	model.Put([]byte("k99"), nil)
+	for i := 0; i < 100; i++ {
+		k := []byte(fmt.Sprintf("k%02d", i))
+		v, found, _ := db.Lookup(k)
+		mv, mfound, _ := model.Lookup(k)
+		if found != mfound || !bytes.Equal(v, mv) {
+			t.Fatalf("%s: %q %v != %q %v", k, v, found, mv, mfound)
+		}
+	}
+
+	//the batch is empty after commit
+	db.Put([]byte("k00"), []byte("after"))
+	db.Delete([]byte("k99"))
+	b = db.NewIndexedBatch()
+	defer b.Close()
+	b.Put([]byte("k99"), []byte("batch"))
+	if v, _ := b.Get([]byte("k00")); string(v) != "after" {
+		t.Fatal(string(v))
+	} else if ok, _ := b.Has([]byte("k99")); !ok {
+		t.Fatal("must has pending key")
+	}
+}
+
+type failWriter struct {
+	n int
+}
+
+func (w *failWriter) Put(key, value []byte) error {
+	w.n++
+	return errors.New("fail")
+}
+
+func (w *failWriter) Delete(key []byte) error {
+	w.n++
+	return nil
+}
+
+func TestWriteBatchData(t *testing.T) {
+	db := getTestDB()
+
+	wb := db.NewWriteBatch()
+	defer wb.Close()
+
+	if wb.Count() != 0 || wb.ApproximateSize() != 12 {
+		t.Fatal(wb.Count(), wb.ApproximateSize())
+	}
+
+	wb.Put([]byte("a"), []byte("b"))
+	want := []byte{0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 1, 'a', 1, 'b'}
+	if data, err := wb.Data(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, want) {
+		t.Fatalf("%v", data)
+	}
+
+	wb.Delete([]byte("batch_data_deleted"))
+	wb.Put([]byte("batch_data_big"), bytes.Repeat([]byte("v"), 300))
+	if wb.Count() != 3 {
+		t.Fatal(wb.Count())
+	}
+
+	data, err := wb.Data()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(data) != wb.ApproximateSize() {
+		t.Fatal(len(data), wb.ApproximateSize())
+	}
+
+	m := NewMemDB(nil)
+	m.Put([]byte("batch_data_deleted"), nil)
+	if err = wb.Iterate(m); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.Get([]byte("batch_data_big")); len(v) != 300 {
+		t.Fatal(len(v))
+	} else if ok, _ := m.Has([]byte("batch_data_deleted")); ok {
+		t.Fatal("must deleted")
+	}
+
+	//stops at the first error
+	f := new(failWriter)
+	if err = wb.Iterate(f); err == nil || f.n != 1 {
+		t.Fatal(err, f.n)
+	}
+
+	wb2 := db.NewWriteBatch()
+	defer wb2.Close()
+	wb2.Put([]byte("replaced"), nil)
+	if err = wb2.SetData(data); err != nil {
+		t.Fatal(err)
+	} else if wb2.Count() != 3 || wb2.ApproximateSize() != len(data) {
+		t.Fatal(wb2.Count(), wb2.ApproximateSize())
+	}
+
+	db.Put([]byte("batch_data_deleted"), nil)
+	if err = wb2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := db.Get([]byte("a")); string(v) != "b" {
+		t.Fatal(string(v))
+	} else if ok, _ := db.Has([]byte("batch_data_deleted")); ok {
+		t.Fatal("must deleted")
+	} else if ok, _ := db.Has([]byte("replaced")); ok {
+		t.Fatal("must replaced")
+	}
+
+	for _, bad := range [][]byte{
+		data[:10],
+		data[:len(data)-1],
+		append(append([]byte{}, data...), 7),
+		append(append([]byte{}, data...), 0, 1, 'x'),
+	} {
+		if err = wb2.SetData(bad); !errors.Is(err, ErrCorruption) {
+			t.Fatal(err)
+		}
+	}
+	if wb2.Count() != 3 {
+		t.Fatal("must not change on error")
+	}
+
+	wb2.Rollback()
+	if wb2.Count() != 0 || wb2.ApproximateSize() != 12 {
+		t.Fatal(wb2.Count(), wb2.ApproximateSize())
+	}
+}
+
+func TestAutoFlushBatch(t *testing.T) {
+	db := openTestDB(t, "auto_flush")
+
+	b := db.NewAutoFlushBatch(3, 0)
+	defer b.Close()
+	for i := 0; i < 10; i++ {
+		if err := b.Put([]byte(fmt.Sprintf("key_%d", i)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.Committed() != 9 || b.Pending() != 1 {
+		t.Fatal(b.Committed(), b.Pending())
+	} else if ok, _ := db.Has([]byte("key_8")); !ok {
+		t.Fatal("must committed")
+	} else if ok, _ = db.Has([]byte("key_9")); ok {
+		t.Fatal("must pending")
+	}
+
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	} else if b.Committed() != 10 || b.Pending() != 0 {
+		t.Fatal(b.Committed(), b.Pending())
+	}
+
+	//each put adds 11 bytes to the 12 byte header, the third reaches 40
+	sb := db.NewAutoFlushBatch(0, 40)
+	defer sb.Close()
+	for i := 0; i < 4; i++ {
+		sb.Put([]byte("key"), []byte("value"))
+	}
+	if sb.Committed() != 3 || sb.Pending() != 1 {
+		t.Fatal(sb.Committed(), sb.Pending())
+	}
+
+	for i := 0; i < 2500; i++ {
+		db.Put([]byte(fmt.Sprintf("clear_%d", i)), nil)
+	}
+	if err := db.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	it := db.NewIterator()
+	if it.SeekToFirst(); it.Valid() {
+		t.Fatal("must cleared")
+	}
+	it.Close()
+
+	db.Destroy()
+
+	//the first error sticks
+	if err := sb.Flush(); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	} else if err = sb.Delete([]byte("key")); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	} else if !errors.Is(sb.Err(), ErrClosed) {
+		t.Fatal(sb.Err())
+	}
+}
+
+func TestTxn(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("txn_key")
+	db.Put(key, []byte("0"))
+
+	t1 := db.Begin()
+	if v, err := t1.Get(key); err != nil || string(v) != "0" {
+		t.Fatal(string(v), err)
+	}
+	t1.Put(key, []byte("1"))
+	if v, _ := t1.Get(key); string(v) != "1" {
+		t.Fatal(string(v))
+	}
+
+	//a write of t2 to a key t1 has read
+	t2 := db.Begin()
+	t2.Put(key, []byte("2"))
+	if err := t2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var e *Error
+	if err := t1.Commit(); !errors.Is(err, ErrConflict) || !errors.As(err, &e) {
+		t.Fatal(err)
+	} else if err = t1.Put(key, nil); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	} else if it := t1.NewIterator(); it.Valid() || !errors.Is(it.Error(), ErrClosed) {
+		t.Fatal(it.Error())
+	}
+
+	//writes without reads never conflict
+	t3 := db.Begin()
+	t3.Put(key, []byte("3"))
+	db.Put(key, []byte("other"))
+	if err := t3.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	//a missing key read which is created meanwhile
+	missing := []byte("txn_missing")
+	db.Delete(missing)
+	t4 := db.Begin()
+	if ok, _ := t4.Has(missing); ok {
+		t.Fatal("must not has")
+	}
+	db.Put(missing, nil)
+	if err := t4.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatal(err)
+	}
+
+	counter := []byte("txn_counter")
+	db.Put(counter, []byte("0"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				err := db.RunTxn(1000, func(txn *Txn) error {
+					v, err := txn.Get(counter)
+					if err != nil {
+						return err
+					}
+
+					var n int
+					fmt.Sscan(string(v), &n)
+					return txn.Put(counter, []byte(fmt.Sprint(n+1)))
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := db.Get(counter); string(v) != "160" {
+		t.Fatal(string(v))
+	}
+
+	stop := errors.New("stop")
+	if err := db.RunTxn(3, func(txn *Txn) error {
+		txn.Put(counter, nil)
+		return stop
+	}); err != stop {
+		t.Fatal(err)
+	} else if v, _ := db.Get(counter); string(v) != "160" {
+		t.Fatal(string(v))
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("update_counter")
+	a, b := []byte("update_a"), []byte("update_b")
+	db.Delete(key)
+	db.Put(a, []byte("1000"))
+	db.Put(b, []byte("1000"))
+
+	atoi := func(v []byte) int {
+		var n int
+		fmt.Sscan(string(v), &n)
+		return n
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				err := db.Update(key, func(old []byte) ([]byte, error) {
+					return []byte(fmt.Sprint(atoi(old) + 1)), nil
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				//move money between a and b in both directions
+				keys := [][]byte{a, b}
+				if i%2 == 0 {
+					keys = [][]byte{b, a}
+				}
+				err = db.UpdateMany(keys, func(old [][]byte) ([][]byte, error) {
+					return [][]byte{
+						[]byte(fmt.Sprint(atoi(old[0]) - 7)),
+						[]byte(fmt.Sprint(atoi(old[1]) + 7)),
+					}, nil
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	va, _ := db.Get(a)
+	vb, _ := db.Get(b)
+	if v, _ := db.Get(key); string(v) != "400" {
+		t.Fatal(string(v))
+	} else if atoi(va)+atoi(vb) != 2000 || atoi(va) != 1000 {
+		t.Fatal(string(va), string(vb))
+	}
+
+	stop := errors.New("stop")
+	if err := db.Update(key, func(old []byte) ([]byte, error) { return nil, stop }); err != stop {
+		t.Fatal(err)
+	} else if v, _ := db.Get(key); string(v) != "400" {
+		t.Fatal(string(v))
+	}
+
+	if err := db.Update(key, func(old []byte) ([]byte, error) { return nil, nil }); err != nil {
+		t.Fatal(err)
+	} else if ok, _ := db.Has(key); ok {
+		t.Fatal("must deleted")
+	}
+
+	if err := db.UpdateMany([][]byte{a, b}, func(old [][]byte) ([][]byte, error) {
+		return old[:1], nil
+	}); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal(err)
+	}
+}
+
+func TestTxnUpdate(t *testing.T) {
+	db := openTestDB(t, "txn_update")
+
+	key := []byte("counter")
+	db.Put(key, []byte("0"))
+
+	//a Txn commit waits for an Update of a key it read, then conflicts
+	inside := make(chan struct{})
+	release := make(chan struct{})
+	updated := make(chan error)
+	go func() {
+		updated <- db.Update(key, func(old []byte) ([]byte, error) {
+			close(inside)
+			<-release
+			return []byte("update"), nil
+		})
+	}()
+	<-inside
+
+	txn := db.Begin()
+	if _, err := txn.Get(key); err != nil {
+		t.Fatal(err)
+	}
+	txn.Put(key, []byte("txn"))
+
+	committed := make(chan error)
+	go func() {
+		committed <- txn.Commit()
+	}()
+
+	select {
+	case err := <-committed:
+		t.Fatal("must wait for the update", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-updated; err != nil {
+		t.Fatal(err)
+	} else if err = <-committed; !errors.Is(err, ErrConflict) {
+		t.Fatal(err)
+	}
+
+	if v, _ := db.Get(key); string(v) != "update" {
+		t.Fatal(string(v))
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	db := getTestDB()
+
+	key := []byte("atomic_counter")
+	fkey := []byte("atomic_float")
+	db.Delete(key)
+	db.Delete(fkey)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := db.IncrBy(key, 3); err != nil {
+					t.Error(err)
+				} else if _, err = db.IncrByFloat(fkey, 0.5); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, err := db.IncrBy(key, -400); err != nil || n != 2000 {
+		t.Fatal(n, err)
+	} else if v, _ := db.Get(key); !bytes.Equal(v, []byte{0, 0, 0, 0, 0, 0, 0x07, 0xd0}) {
+		t.Fatalf("%x", v)
+	}
+
+	if f, err := db.IncrByFloat(fkey, -0.25); err != nil || f != 399.75 {
+		t.Fatal(f, err)
+	}
+
+	db.Put(key, []byte("text"))
+	if _, err := db.IncrBy(key, 1); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatal(err)
+	}
+
+	cas := []byte("atomic_cas")
+	db.Delete(cas)
+	if err := db.CompareAndSwap(cas, []byte{}, []byte("a")); !errors.Is(err, ErrCASMismatch) {
+		t.Fatal(err)
+	} else if err = db.SetIfAbsent(cas, nil); err != nil {
+		t.Fatal(err)
+	} else if err = db.SetIfAbsent(cas, []byte("b")); !errors.Is(err, ErrCASMismatch) {
+		t.Fatal(err)
+	} else if err = db.CompareAndSwap(cas, nil, []byte("a")); !errors.Is(err, ErrCASMismatch) {
+		t.Fatal(err)
+	} else if err = db.CompareAndSwap(cas, []byte{}, []byte("a")); err != nil {
+		t.Fatal(err)
+	} else if err = db.CompareAndSwap(cas, []byte("b"), []byte("c")); !errors.Is(err, ErrCASMismatch) {
+		t.Fatal(err)
+	} else if v, _ := db.Get(cas); string(v) != "a" {
+		t.Fatal(string(v))
+	} else if err = db.CompareAndSwap(cas, []byte("a"), nil); err != nil {
+		t.Fatal(err)
+	} else if ok, _ := db.Has(cas); ok {
+		t.Fatal("must deleted")
+	}
+}
+
+func TestSafeClose(t *testing.T) {
+	db := openTestDB(t, "safe_close")
+
+	//callers racing with Close get ErrClosed, not a crash
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := []byte(fmt.Sprintf("key_%d", i))
+			for {
+				if err := db.Put(key, key); err != nil {
+					if !errors.Is(err, ErrClosed) {
+						t.Error(err)
+					}
+					return
+				}
+
+				it := db.NewIterator()
+				for it.SeekToFirst(); it.Valid(); it.Next() {
+				}
+				it.Close()
+
+				if _, err := db.Get(key); err != nil && !errors.Is(err, ErrClosed) {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	it := db.NewIterator()
+	s := db.NewSnapshot()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := db.CloseContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	//open iterator and snapshot still work
+	it.SeekToFirst()
+	if !it.Valid() {
+		t.Fatal("must valid")
+	} else if _, err := s.Get([]byte("key_0")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Get([]byte("key_0")); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	} else if nit := db.NewIterator(); nit.Valid() || !errors.Is(nit.Error(), ErrClosed) {
+		t.Fatal(nit.Error())
+	}
+
+	ns := db.NewSnapshot()
+	if _, err := ns.Get([]byte("key_0")); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	}
+	ns.Close()
+
+	closed := make(chan error)
+	go func() {
+		closed <- db.Close()
+	}()
+
+	it.Close()
+	it.Close()
+	select {
+	case <-closed:
+		t.Fatal("must wait for the snapshot")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	s.Close()
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	} else if _, err = s.Get([]byte("key_0")); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenHandles(t *testing.T) {
+	cfg := newTestConfig("open_handles")
+	cfg.DebugHandles = true
+	l := new(testLogger)
+	cfg.Logger = l
+	db := openTestDBWithConfig(t, cfg)
+
+	it := db.NewIterator()
+	s := db.NewSnapshot()
+	wb := db.NewWriteBatch()
+
+	hs := db.OpenHandles()
+	if len(hs) != 3 {
+		t.Fatal(len(hs))
+	}
+	for i, kind := range []HandleKind{HandleIterator, HandleSnapshot, HandleWriteBatch} {
+		if hs[i].Kind != kind {
+			t.Fatal(i, hs[i].Kind)
+		} else if !strings.Contains(hs[i].Stack, "TestOpenHandles") {
+			t.Fatal(hs[i].Stack)
+		}
+	}
+
+	it.Close()
+	s.Close()
+	wb.Close()
+	wb.Close()
+	if hs = db.OpenHandles(); len(hs) != 0 {
+		t.Fatal(len(hs))
+	}
+
+	//leaked handles are logged and released by their finalizers
+	func() {
+		db.NewIterator().SeekToFirst()
+		db.NewSnapshot().Get([]byte("a"))
+		db.NewWriteBatch().Put([]byte("a"), []byte("1"))
+	}()
+
+	for i := 0; len(db.OpenHandles()) > 0; i++ {
+		if i == 100 {
+			t.Fatal("handles are not finalized")
+		}
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+
+	leaks := 0
+	l.Lock()
+	for i, msg := range l.msgs {
+		if strings.Contains(msg, "was not closed") {
+			leaks++
+			if l.levels[i] != LogWarn || !strings.Contains(msg, "TestOpenHandles") {
+				t.Error(l.levels[i], msg)
+			}
+		}
+	}
+	l.Unlock()
+	if leaks != 3 {
+		t.Fatal(leaks)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := db.CloseContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContext(t *testing.T) {
+	db := openTestDB(t, "context")
+
+	ctx := context.Background()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		if err := db.PutContext(ctx, key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.PutContext(canceled, []byte("a"), []byte("1")); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	} else if err = db.DeleteContext(canceled, []byte("key_0")); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	} else if _, err = db.GetContext(canceled, []byte("key_0")); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+
+	if v, err := db.GetContext(ctx, []byte("key_0")); err != nil {
+		t.Fatal(err)
+	} else if string(v) != "key_0" {
+		t.Fatal(string(v))
+	}
+
+	var keys []string
+	collect := func(key, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}
+
+	if err := db.ScanContext(ctx, []byte("key_2"), nil, RangeClose, 1, 3, collect); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(keys, ",") != "key_3,key_4,key_5" {
+		t.Fatal(keys)
+	}
+
+	keys = nil
+	if err := db.RevScanContext(ctx, nil, []byte("key_2"), RangeROpen, 0, -1, collect); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(keys, ",") != "key_1,key_0" {
+		t.Fatal(keys)
+	}
+
+	//cancelled between steps
+	stop, cancel := context.WithCancel(ctx)
+	n := 0
+	err := db.ScanContext(stop, nil, nil, RangeClose, 0, -1, func(key, value []byte) error {
+		if n++; n == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || n != 3 {
+		t.Fatal(err, n)
+	}
+
+	errStop := errors.New("stop")
+	if err = db.ScanContext(ctx, nil, nil, RangeClose, 0, -1, func(key, value []byte) error {
+		return errStop
+	}); err != errStop {
+		t.Fatal(err)
+	}
+
+	s := db.NewSnapshot()
+	db.Delete([]byte("key_9"))
+
+	if v, err := s.GetContext(ctx, []byte("key_9")); err != nil || string(v) != "key_9" {
+		t.Fatal(string(v), err)
+	} else if _, err = s.GetContext(canceled, []byte("key_9")); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+
+	keys = nil
+	if err = s.ScanContext(ctx, []byte("key_8"), nil, RangeClose, 0, -1, collect); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(keys, ",") != "key_8,key_9" {
+		t.Fatal(keys)
+	}
+
+	keys = nil
+	if err = s.RevScanContext(ctx, []byte("key_8"), nil, RangeClose, 0, 1, collect); err != nil {
+		t.Fatal(err)
+	} else if strings.Join(keys, ",") != "key_9" {
+		t.Fatal(keys)
+	} else if err = s.ScanContext(canceled, nil, nil, RangeClose, 0, -1, collect); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if err = db.CompactRangeContext(canceled, nil, nil); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	} else if err = db.CompactRangeContext(ctx, []byte("key_0"), []byte("key_5")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.ClearContext(canceled); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	} else if ok, _ := db.Has([]byte("key_0")); !ok {
+		t.Fatal("must not be cleared")
+	}
+
+	if err = db.ClearContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	it := db.NewIterator()
+	it.SeekToFirst()
+	if it.Valid() {
+		t.Fatal("must be cleared")
+	}
+	it.Close()
+}
+
+func TestDestroy(t *testing.T) {
+	db := getTestDB()
+
+	db.Put([]byte("a"), []byte("1"))
+	if err := db.Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(db.cfg.Path); err != nil {
+		t.Fatal("must exist ", err.Error())
+	}
+
+	if v, err := db.Get([]byte("a")); err != nil {
+		t.Fatal(err)
+	} else if string(v) == "1" {
+		t.Fatal(string(v))
+	}
+
+	db.Destroy()
+
+	if _, err := os.Stat(db.cfg.Path); !os.IsNotExist(err) {
+		t.Fatal("must not exist")
+	}
+}
+
+func TestCloseMore(t *testing.T) {
+	cfg := new(Config)
+	cfg.Path = "/tmp/testdb1234"
+	cfg.CacheSize = 4 * 1024 * 1024
+	os.RemoveAll(cfg.Path)
+	for i := 0; i < 100; i++ {
+		db, err := OpenWithConfig(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db.Put([]byte("key"), []byte("value"))
+
+		db.Close()
+	}
+}
+
+func openBenchDB(b *testing.B, keys [][]byte) *DB {
+	db := openTestDB(b, "bench")
+
+	for _, key := range keys {
+		db.Put(key, bytes.Repeat([]byte("v"), 100))
+	}
+	return db
+}
+
+func benchKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("bench_key_%06d", i))
+	}
+	return keys
+}
+
+func BenchmarkGetLoop(b *testing.B) {
+	keys := benchKeys(32)
+	db := openBenchDB(b, keys)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, key := range keys {
+			if _, err := db.Get(key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkMultiGet(b *testing.B) {
+	keys := benchKeys(32)
+	db := openBenchDB(b, keys)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := db.MultiGet(keys); err != nil {
+			b.Fatal(err)
+		}
 	}
 }
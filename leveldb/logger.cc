@@ -0,0 +1,62 @@
+#include <stdarg.h>
+#include <stdio.h>
+#include <string>
+
+#include "leveldb/env.h"
+
+#include "go_leveldb.h"
+#include "_cgo_export.h"
+
+// Same layout as in leveldb's db/c.cc, which has no constructor for it.
+struct leveldb_logger_t {
+  leveldb::Logger* rep;
+};
+
+namespace {
+
+// GoLogger formats leveldb's info log lines and passes them to a Go Logger.
+class GoLogger : public leveldb::Logger {
+ public:
+  explicit GoLogger(uintptr_t handle) : handle_(handle) {}
+
+  void Logv(const char* format, va_list ap) override {
+    char buf[512];
+    va_list backup;
+    va_copy(backup, ap);
+    int n = vsnprintf(buf, sizeof(buf), format, ap);
+    if (n < 0) {
+      va_end(backup);
+      return;
+    }
+
+    std::string msg;
+    if (static_cast<size_t>(n) < sizeof(buf)) {
+      msg.assign(buf, n);
+    } else {
+      msg.resize(n + 1);
+      vsnprintf(&msg[0], n + 1, format, backup);
+      msg.resize(n);
+    }
+    va_end(backup);
+
+    while (!msg.empty() && msg[msg.size() - 1] == '\n') {
+      msg.resize(msg.size() - 1);
+    }
+
+    goLoggerLog(handle_, const_cast<char*>(msg.data()), msg.size());
+  }
+
+ private:
+  uintptr_t handle_;
+};
+
+}  // namespace
+
+leveldb_logger_t* go_leveldb_logger_create(uintptr_t handle) {
+  return new leveldb_logger_t{new GoLogger(handle)};
+}
+
+void go_leveldb_logger_destroy(leveldb_logger_t* logger) {
+  delete logger->rep;
+  delete logger;
+}
@@ -0,0 +1,111 @@
+package leveldb
+
+// #cgo LDFLAGS: -lleveldb
+// #include "go_leveldb.h"
+import "C"
+
+import (
+	"context"
+	"log/slog"
+	"runtime/cgo"
+	"strings"
+)
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Logger receives leveldb's info log, such as compaction and recovery
+// messages. Log may be called concurrently from leveldb's background thread.
+type Logger interface {
+	Log(level LogLevel, msg string)
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger returns a Logger writing to l.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{l}
+}
+
+func (s *slogLogger) Log(level LogLevel, msg string) {
+	var l slog.Level
+	switch level {
+	case LogDebug:
+		l = slog.LevelDebug
+	case LogInfo:
+		l = slog.LevelInfo
+	case LogWarn:
+		l = slog.LevelWarn
+	default:
+		l = slog.LevelError
+	}
+
+	s.l.Log(context.Background(), l, msg)
+}
+
+// leveldb logs without levels, so guess them from the message
+func inferLogLevel(msg string) LogLevel {
+	lower := strings.ToLower(msg)
+
+	switch {
+	case strings.HasPrefix(msg, "Ignoring error"),
+		strings.Contains(lower, "dropping"):
+		return LogWarn
+	case strings.Contains(lower, "error"),
+		strings.Contains(msg, "Corruption"):
+		return LogError
+	case strings.HasPrefix(msg, "Delete type="):
+		return LogDebug
+	}
+
+	return LogInfo
+}
+
+// logger binds a Go Logger to a leveldb logger.
+type logger struct {
+	Log *C.leveldb_logger_t
+
+	handle cgo.Handle
+}
+
+func newLogger(l Logger) *logger {
+	lg := new(logger)
+	lg.handle = cgo.NewHandle(l)
+	lg.Log = C.go_leveldb_logger_create(C.uintptr_t(lg.handle))
+	return lg
+}
+
+func (lg *logger) Close() {
+	C.go_leveldb_logger_destroy(lg.Log)
+	lg.handle.Delete()
+}
+
+//export goLoggerLog
+func goLoggerLog(h C.uintptr_t, msg *C.char, n C.size_t) {
+	l := cgo.Handle(h).Value().(Logger)
+
+	m := C.GoStringN(msg, C.int(n))
+	l.Log(inferLogLevel(m), m)
+}
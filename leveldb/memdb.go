@@ -0,0 +1,191 @@
+package leveldb
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemDB is a Reader and Writer keeping its data in a sorted Go slice.
+// It is meant as a reference implementation of the interfaces, e.g. for
+// testing code written against them without a database on disk, not for
+// large amounts of data: every write copies the slice.
+//
+// MemDB is safe for concurrent use. Its iterators see the data as of their
+// creation, like those of a DB.
+type MemDB struct {
+	mu sync.RWMutex
+
+	cmp Comparator
+
+	// replaced on every write, never modified, so iterators can share it
+	kvs []memKV
+}
+
+type memKV struct {
+	key   []byte
+	value []byte
+
+	// only used by IndexedBatch
+	deleted bool
+}
+
+// NewMemDB returns an empty MemDB ordering keys by cmp, nil for BytewiseComparator.
+func NewMemDB(cmp Comparator) *MemDB {
+	if cmp == nil {
+		cmp = BytewiseComparator
+	}
+	return &MemDB{cmp: cmp}
+}
+
+func (m *MemDB) Put(key, value []byte) error {
+	kv := memKV{key: append([]byte{}, key...), value: append([]byte{}, value...)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, found := memSearch(m.kvs, m.cmp, key)
+	kvs := make([]memKV, 0, len(m.kvs)+1)
+	kvs = append(kvs, m.kvs[:i]...)
+	kvs = append(kvs, kv)
+	if found {
+		i++
+	}
+	m.kvs = append(kvs, m.kvs[i:]...)
+	return nil
+}
+
+func (m *MemDB) Delete(key []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, found := memSearch(m.kvs, m.cmp, key)
+	if !found {
+		return nil
+	}
+
+	kvs := make([]memKV, 0, len(m.kvs)-1)
+	kvs = append(kvs, m.kvs[:i]...)
+	m.kvs = append(kvs, m.kvs[i+1:]...)
+	return nil
+}
+
+func (m *MemDB) Get(key []byte) ([]byte, error) {
+	value, _, err := m.Lookup(key)
+	return value, err
+}
+
+func (m *MemDB) Lookup(key []byte) ([]byte, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i, found := memSearch(m.kvs, m.cmp, key)
+	if !found {
+		return nil, false, nil
+	}
+	return append([]byte{}, m.kvs[i].value...), true, nil
+}
+
+func (m *MemDB) Has(key []byte) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, found := memSearch(m.kvs, m.cmp, key)
+	return found, nil
+}
+
+func (m *MemDB) NewIterator() *Iterator {
+	m.mu.RLock()
+	kvs := m.kvs
+	m.mu.RUnlock()
+
+	it := new(Iterator)
+	it.impl = &memIterator{kvs: kvs, cmp: m.cmp, pos: -1}
+	it.cmp = m.cmp
+	return it
+}
+
+func (m *MemDB) RangeIterator(min []byte, max []byte, rangeType uint8) *RangeLimitIterator {
+	return NewRangeLimitIterator(m.NewIterator(), &Range{min, max, rangeType}, &Limit{0, -1})
+}
+
+func (m *MemDB) RevRangeIterator(min []byte, max []byte, rangeType uint8) *RangeLimitIterator {
+	return NewRevRangeLimitIterator(m.NewIterator(), &Range{min, max, rangeType}, &Limit{0, -1})
+}
+
+func (m *MemDB) RangeLimitIterator(min []byte, max []byte, rangeType uint8, offset int, count int) *RangeLimitIterator {
+	return NewRangeLimitIterator(m.NewIterator(), &Range{min, max, rangeType}, &Limit{offset, count})
+}
+
+func (m *MemDB) RevRangeLimitIterator(min []byte, max []byte, rangeType uint8, offset int, count int) *RangeLimitIterator {
+	return NewRevRangeLimitIterator(m.NewIterator(), &Range{min, max, rangeType}, &Limit{offset, count})
+}
+
+func (m *MemDB) PrefixIterator(prefix []byte) *RangeLimitIterator {
+	return NewRangeLimitIterator(m.NewIterator(), prefixRange(prefix), &Limit{0, -1})
+}
+
+func (m *MemDB) RevPrefixIterator(prefix []byte) *RangeLimitIterator {
+	return NewRevRangeLimitIterator(m.NewIterator(), prefixRange(prefix), &Limit{0, -1})
+}
+
+// memSearch returns the index of the first entry >= key and whether its key is key.
+func memSearch(kvs []memKV, cmp Comparator, key []byte) (int, bool) {
+	i := sort.Search(len(kvs), func(i int) bool {
+		return cmp.Compare(kvs[i].key, key) >= 0
+	})
+	return i, i < len(kvs) && cmp.Compare(kvs[i].key, key) == 0
+}
+
+type memIterator struct {
+	kvs []memKV
+	cmp Comparator
+
+	// invalid if out of kvs
+	pos int
+}
+
+func (it *memIterator) Valid() bool {
+	return it.pos >= 0 && it.pos < len(it.kvs)
+}
+
+func (it *memIterator) Key() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.kvs[it.pos].key
+}
+
+func (it *memIterator) Value() []byte {
+	if !it.Valid() {
+		return nil
+	}
+	return it.kvs[it.pos].value
+}
+
+func (it *memIterator) Next() {
+	it.pos++
+}
+
+func (it *memIterator) Prev() {
+	it.pos--
+}
+
+func (it *memIterator) SeekToFirst() {
+	it.pos = 0
+}
+
+func (it *memIterator) SeekToLast() {
+	it.pos = len(it.kvs) - 1
+}
+
+func (it *memIterator) Seek(key []byte) {
+	it.pos, _ = memSearch(it.kvs, it.cmp, key)
+}
+
+func (it *memIterator) Error() error {
+	return nil
+}
+
+func (it *memIterator) Close() {
+	it.kvs = nil
+}
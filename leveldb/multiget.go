@@ -0,0 +1,75 @@
+package leveldb
+
+// #cgo LDFLAGS: -lleveldb
+// #include "go_leveldb.h"
+import "C"
+
+import (
+	"unsafe"
+)
+
+// MultiGet looks up keys with a single call into leveldb, all of them read
+// from the same implicit snapshot. values[i] is nil and found[i] is false if
+// keys[i] does not exist, an empty value is a non-nil empty slice.
+// The values share one buffer, appending to one of them never changes another.
+func (db *DB) MultiGet(keys [][]byte) (values [][]byte, found []bool, err error) {
+	return db.multiGet(db.readOpts, keys)
+}
+
+func (db *DB) multiGet(ro *ReadOptions, keys [][]byte) ([][]byte, []bool, error) {
+	if err := db.acquireRead(ro); err != nil {
+		return nil, nil, err
+	}
+	defer db.release()
+
+	n := len(keys)
+	if n == 0 {
+		return nil, nil, nil
+	}
+
+	size := 0
+	for _, key := range keys {
+		size += len(key)
+	}
+
+	//keys are passed concatenated, so C never sees the Go pointers in keys
+	buf := make([]byte, 0, size+1)
+	keylens := make([]C.size_t, n)
+	for i, key := range keys {
+		buf = append(buf, key...)
+		keylens[i] = C.size_t(len(key))
+	}
+	buf = append(buf, 0)
+
+	vallens := make([]C.size_t, n)
+	flags := make([]C.uchar, n)
+
+	var total C.size_t
+	var errStr *C.char
+	data := C.go_leveldb_multi_get(db.db, ro.Opt,
+		(*C.char)(unsafe.Pointer(&buf[0])), &keylens[0], C.size_t(n),
+		&vallens[0], &flags[0], &total, &errStr)
+
+	if errStr != nil {
+		return nil, nil, saveError(db.cfg.Path, errStr)
+	}
+
+	all := C.GoBytes(unsafe.Pointer(data), C.int(total))
+	C.leveldb_free(unsafe.Pointer(data))
+
+	values := make([][]byte, n)
+	found := make([]bool, n)
+	pos := 0
+	for i := range keys {
+		if !ucharToBool(flags[i]) {
+			continue
+		}
+
+		end := pos + int(vallens[i])
+		values[i] = all[pos:end:end]
+		found[i] = true
+		pos = end
+	}
+
+	return values, found, nil
+}
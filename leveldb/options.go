@@ -17,6 +17,10 @@ type Options struct {
 
 type ReadOptions struct {
 	Opt *C.leveldb_readoptions_t
+
+	//set by SetSnapshot, the inner state so the Snapshot itself can
+	//still become unreachable and be finalized
+	snap *snapshot
 }
 
 type WriteOptions struct {
@@ -30,7 +34,7 @@ func NewOptions() *Options {
 
 func NewReadOptions() *ReadOptions {
 	opt := C.leveldb_readoptions_create()
-	return &ReadOptions{opt}
+	return &ReadOptions{Opt: opt}
 }
 
 func NewWriteOptions() *WriteOptions {
@@ -55,9 +59,9 @@ func (o *Options) SetCache(cache *Cache) {
 	C.leveldb_options_set_cache(o.Opt, cache.Cache)
 }
 
-// func (o *Options) SetEnv(env *Env) {
-// 	C.leveldb_options_set_env(o.Opt, env.Env)
-// }
+func (o *Options) SetEnv(env *Env) {
+	C.leveldb_options_set_env(o.Opt, env.Env)
+}
 
 func (o *Options) SetInfoLog(log *C.leveldb_logger_t) {
 	C.leveldb_options_set_info_log(o.Opt, log)
@@ -113,8 +117,10 @@ func (ro *ReadOptions) SetFillCache(b bool) {
 
 func (ro *ReadOptions) SetSnapshot(snap *Snapshot) {
 	var s *C.leveldb_snapshot_t
+	ro.snap = nil
 	if snap != nil {
 		s = snap.snap
+		ro.snap = snap.snapshot
 	}
 	C.leveldb_readoptions_set_snapshot(ro.Opt, s)
 }
@@ -0,0 +1,86 @@
+//go:build go1.23
+
+package leveldb
+
+import (
+	"iter"
+)
+
+// All returns an iterator over all key-value pairs in key order, for use in
+// range-over-func loops, and a function returning the error which stopped
+// the iteration early, if any. The underlying leveldb iterator is closed when
+// the loop ends, also on break. Keys and values are copies and may be retained.
+//
+// Each loop over seq reads the database anew, and errf reports the error of
+// the last one, so seq must not be ranged over from several goroutines at
+// once. Call All again for each concurrent loop instead.
+//
+//	seq, errf := db.All()
+//	for k, v := range seq {
+//		...
+//	}
+//	if err := errf(); err != nil {
+//		...
+//	}
+func (db *DB) All() (iter.Seq2[[]byte, []byte], func() error) {
+	return db.seq(func() *RangeLimitIterator {
+		return db.RangeIterator(nil, nil, RangeClose)
+	})
+}
+
+// Range is like All, for the key-value pairs in r.
+func (db *DB) Range(r Range) (iter.Seq2[[]byte, []byte], func() error) {
+	return db.seq(func() *RangeLimitIterator {
+		return db.RangeIterator(r.Min, r.Max, r.Type)
+	})
+}
+
+// Prefix is like All, for the keys starting with prefix.
+func (db *DB) Prefix(prefix []byte) (iter.Seq2[[]byte, []byte], func() error) {
+	return db.seq(func() *RangeLimitIterator {
+		return db.PrefixIterator(prefix)
+	})
+}
+
+// All is like DB.All, reading from s.
+func (s *Snapshot) All() (iter.Seq2[[]byte, []byte], func() error) {
+	return s.db.seq(func() *RangeLimitIterator {
+		return s.RangeIterator(nil, nil, RangeClose)
+	})
+}
+
+// Range is like DB.Range, reading from s.
+func (s *Snapshot) Range(r Range) (iter.Seq2[[]byte, []byte], func() error) {
+	return s.db.seq(func() *RangeLimitIterator {
+		return s.RangeIterator(r.Min, r.Max, r.Type)
+	})
+}
+
+// Prefix is like DB.Prefix, reading from s.
+func (s *Snapshot) Prefix(prefix []byte) (iter.Seq2[[]byte, []byte], func() error) {
+	return s.db.seq(func() *RangeLimitIterator {
+		return s.PrefixIterator(prefix)
+	})
+}
+
+// seq iterates a new iterator of newIt each time it is ranged over. The loops
+// share err, so they must not run concurrently.
+func (db *DB) seq(newIt func() *RangeLimitIterator) (iter.Seq2[[]byte, []byte], func() error) {
+	var err error
+
+	seq := func(yield func([]byte, []byte) bool) {
+		it := newIt()
+		defer it.Close()
+
+		err = nil
+		for ; it.Valid(); it.Next() {
+			if !yield(it.Key(), it.Value()) {
+				return
+			}
+		}
+
+		err = it.Error()
+	}
+
+	return seq, func() error { return err }
+}
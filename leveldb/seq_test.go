@@ -0,0 +1,85 @@
+//go:build go1.23
+
+package leveldb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSeq(t *testing.T) {
+	db := openTestDB(t, "seq")
+
+	for _, k := range []string{"a", "b1", "b2", "b3", "c"} {
+		db.Put([]byte(k), []byte(strings.ToUpper(k)))
+	}
+
+	s := db.NewSnapshot()
+	db.Delete([]byte("b2"))
+
+	keys := func(seq func(func([]byte, []byte) bool), errf func() error, max int) string {
+		var ks []string
+		for k, v := range seq {
+			if strings.ToUpper(string(k)) != string(v) {
+				t.Fatal(string(k), string(v))
+			}
+
+			ks = append(ks, string(k))
+			if len(ks) == max {
+				break
+			}
+		}
+
+		if err := errf(); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(ks, " ")
+	}
+
+	seq, errf := db.All()
+	if ks := keys(seq, errf, -1); ks != "a b1 b3 c" {
+		t.Fatal(ks)
+	}
+
+	//every loop gets a new iterator
+	if ks := keys(seq, errf, 2); ks != "a b1" {
+		t.Fatal(ks)
+	}
+
+	seq, errf = db.Range(Range{[]byte("a"), []byte("b3"), RangeOpen})
+	if ks := keys(seq, errf, -1); ks != "b1" {
+		t.Fatal(ks)
+	}
+
+	seq, errf = db.Prefix([]byte("b"))
+	if ks := keys(seq, errf, -1); ks != "b1 b3" {
+		t.Fatal(ks)
+	}
+
+	seq, errf = s.All()
+	if ks := keys(seq, errf, -1); ks != "a b1 b2 b3 c" {
+		t.Fatal(ks)
+	}
+
+	seq, errf = s.Range(Range{[]byte("b2"), nil, RangeClose})
+	if ks := keys(seq, errf, 2); ks != "b2 b3" {
+		t.Fatal(ks)
+	}
+
+	seq, errf = s.Prefix([]byte("b"))
+	if ks := keys(seq, errf, -1); ks != "b1 b2 b3" {
+		t.Fatal(ks)
+	}
+
+	s.Close()
+	db.Destroy()
+
+	seq, errf = db.All()
+	for range seq {
+		t.Fatal("must not iterate a closed db")
+	}
+	if err := errf(); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	}
+}
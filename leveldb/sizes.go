@@ -0,0 +1,253 @@
+package leveldb
+
+// #cgo LDFLAGS: -lleveldb
+// #include <stdlib.h>
+// #include "leveldb/c.h"
+import "C"
+
+import (
+	"bytes"
+	"math/big"
+	"unsafe"
+)
+
+// split keys get some extra bytes so close bounds can still be bisected
+const splitKeyExtraBytes = 4
+
+// ApproximateSizes returns the approximate on-disk size in bytes of each range.
+// A nil Min or Max means the range is unbounded on that side.
+// Data which is still in the memtable is not counted until it is compacted.
+// With a custom comparator open bounds are not excluded, as the keys next to
+// them are unknown, and nil bounds are the first and last key, which is
+// itself not counted.
+func (db *DB) ApproximateSizes(ranges []Range) ([]uint64, error) {
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+
+	if err := db.acquire(); err != nil {
+		return nil, err
+	}
+	defer db.release()
+
+	return db.approximateSizes(ranges), nil
+}
+
+// approximateSizes is ApproximateSizes for callers holding a reference to db.
+func (db *DB) approximateSizes(ranges []Range) []uint64 {
+	n := len(ranges)
+
+	starts := make([]*C.char, n)
+	startLens := make([]C.size_t, n)
+	limits := make([]*C.char, n)
+	limitLens := make([]C.size_t, n)
+
+	defer func() {
+		for i := 0; i < n; i++ {
+			C.free(unsafe.Pointer(starts[i]))
+			C.free(unsafe.Pointer(limits[i]))
+		}
+	}()
+
+	var first, end []byte
+	for i := range ranges {
+		var start, limit []byte
+		if db.cmp == BytewiseComparator {
+			start = rangeStart(&ranges[i])
+			limit = rangeLimit(&ranges[i])
+		} else {
+			start, limit = ranges[i].Min, ranges[i].Max
+			if start == nil {
+				if first == nil {
+					first = db.firstKey()
+				}
+				start = first
+			}
+		}
+
+		if limit == nil {
+			if end == nil {
+				end = db.endKey()
+			}
+			limit = end
+		}
+
+		starts[i] = (*C.char)(C.CBytes(start))
+		startLens[i] = C.size_t(len(start))
+		limits[i] = (*C.char)(C.CBytes(limit))
+		limitLens[i] = C.size_t(len(limit))
+	}
+
+	sizes := make([]C.uint64_t, n)
+	C.leveldb_approximate_sizes(db.db, C.int(n),
+		&starts[0], &startLens[0], &limits[0], &limitLens[0], &sizes[0])
+
+	s := make([]uint64, n)
+	for i, v := range sizes {
+		s[i] = uint64(v)
+	}
+	return s
+}
+
+// SplitRange splits r into at most n contiguous sub-ranges of roughly equal
+// approximate size. The boundaries are found by bisecting the key space
+// between the bounds of r, so they are not necessarily existing keys.
+// If nothing in r has been compacted to disk yet, the key space is split evenly.
+// Bisecting needs the bytewise key order, r is not split with a custom comparator.
+func (db *DB) SplitRange(r Range, n int) ([]Range, error) {
+	if n <= 1 || db.cmp != BytewiseComparator {
+		return []Range{r}, nil
+	}
+
+	if err := db.acquire(); err != nil {
+		return nil, err
+	}
+	defer db.release()
+
+	return db.splitRange(r, n), nil
+}
+
+// splitRange is SplitRange for callers holding a reference to db.
+func (db *DB) splitRange(r Range, n int) []Range {
+
+	lo := rangeStart(&r)
+	hi := rangeLimit(&r)
+	if hi == nil {
+		hi = db.endKey()
+	}
+
+	if bytes.Compare(lo, hi) >= 0 {
+		return []Range{r}
+	}
+
+	width := len(lo)
+	if len(hi) > width {
+		width = len(hi)
+	}
+	width += splitKeyExtraBytes
+
+	a := keyToInt(lo, width)
+	b := keyToInt(hi, width)
+
+	total := db.approximateSize(lo, hi)
+
+	bounds := make([][]byte, 0, n-1)
+	prev := new(big.Int).Set(a)
+	for i := 1; i < n; i++ {
+		k := new(big.Int)
+		if total == 0 {
+			//a + (b - a) * i / n
+			k.Sub(b, a)
+			k.Mul(k, big.NewInt(int64(i)))
+			k.Div(k, big.NewInt(int64(n)))
+			k.Add(k, a)
+		} else {
+			target := uint64(float64(total) * float64(i) / float64(n))
+
+			//find the smallest key whose size from lo reaches target
+			l := new(big.Int).Set(prev)
+			h := new(big.Int).Set(b)
+			mid := new(big.Int)
+			for new(big.Int).Sub(h, l).Cmp(big.NewInt(1)) > 0 {
+				mid.Add(l, h)
+				mid.Rsh(mid, 1)
+
+				if db.approximateSize(lo, intToKey(mid, width)) < target {
+					l.Set(mid)
+				} else {
+					h.Set(mid)
+				}
+			}
+			k.Set(h)
+		}
+
+		key := intToKey(k, width)
+
+		last := lo
+		if len(bounds) > 0 {
+			last = bounds[len(bounds)-1]
+		}
+
+		if bytes.Compare(key, last) <= 0 || bytes.Compare(key, hi) >= 0 {
+			continue
+		}
+
+		bounds = append(bounds, key)
+		prev = k
+	}
+
+	rs := make([]Range, 0, len(bounds)+1)
+	min, t := r.Min, r.Type&RangeLOpen
+	for _, k := range bounds {
+		rs = append(rs, Range{min, k, t | RangeROpen})
+		min, t = k, RangeClose
+	}
+
+	return append(rs, Range{min, r.Max, t | r.Type&RangeROpen})
+}
+
+func (db *DB) approximateSize(start []byte, limit []byte) uint64 {
+	return db.approximateSizes([]Range{{start, limit, RangeROpen}})[0]
+}
+
+// firstKey returns the first key in db, for callers holding a reference.
+func (db *DB) firstKey() []byte {
+	it := db.heldIterator()
+	defer it.Close()
+
+	it.SeekToFirst()
+	if !it.Valid() {
+		return []byte{}
+	}
+
+	return it.Key()
+}
+
+// endKey returns a key greater than all keys in db, or with a custom
+// comparator, which has no such key, the last key. The caller must hold
+// a reference to db.
+func (db *DB) endKey() []byte {
+	it := db.heldIterator()
+	defer it.Close()
+
+	it.SeekToLast()
+	if !it.Valid() {
+		return []byte{}
+	} else if db.cmp != BytewiseComparator {
+		return it.Key()
+	}
+
+	return append(it.Key(), 0)
+}
+
+// rangeStart returns the smallest key in r, in the bytewise order.
+func rangeStart(r *Range) []byte {
+	if r.Min == nil {
+		return []byte{}
+	} else if r.Type&RangeLOpen > 0 {
+		return append(append([]byte{}, r.Min...), 0)
+	}
+	return r.Min
+}
+
+// rangeLimit returns the smallest key greater than all keys in r, or nil if r
+// has no upper bound, in the bytewise order.
+func rangeLimit(r *Range) []byte {
+	if r.Max == nil {
+		return nil
+	} else if r.Type&RangeROpen > 0 {
+		return r.Max
+	}
+	return append(append([]byte{}, r.Max...), 0)
+}
+
+func keyToInt(key []byte, width int) *big.Int {
+	b := make([]byte, width)
+	copy(b, key)
+	return new(big.Int).SetBytes(b)
+}
+
+func intToKey(n *big.Int, width int) []byte {
+	b := n.FillBytes(make([]byte, width))
+	return bytes.TrimRight(b, "\x00")
+}
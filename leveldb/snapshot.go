@@ -5,31 +5,82 @@ package leveldb
 // #include "leveldb/c.h"
 import "C"
 
+import (
+	"runtime"
+)
+
 type Snapshot struct {
+	//the read options refer to this, not to the Snapshot
+	*snapshot
+}
+
+type snapshot struct {
 	db *DB
 
 	snap *C.leveldb_snapshot_t
 
 	readOpts     *ReadOptions
 	iteratorOpts *ReadOptions
+
+	//id in db.handles
+	handle uint64
 }
 
 func (s *Snapshot) Close() {
+	if s.snap == nil {
+		return
+	}
+
+	runtime.SetFinalizer(s, nil)
+	s.close()
+}
+
+func (s *snapshot) close() {
 	C.leveldb_release_snapshot(s.db.db, s.snap)
+	s.snap = nil
 
 	s.iteratorOpts.Close()
 	s.readOpts.Close()
+
+	s.db.untrackHandle(s.handle)
+	s.db.release()
 }
 
 func (s *Snapshot) Get(key []byte) ([]byte, error) {
-	return s.db.get(s.readOpts, key)
+	value, _, err := s.db.get(s.readOpts, key)
+	runtime.KeepAlive(s)
+	return value, err
 }
 
-func (s *Snapshot) NewIterator() *Iterator {
-	it := new(Iterator)
+func (s *Snapshot) GetInto(key []byte, dst []byte) ([]byte, error) {
+	value, err := s.db.getInto(s.readOpts, key, dst)
+	runtime.KeepAlive(s)
+	return value, err
+}
 
-	it.it = C.leveldb_create_iterator(s.db.db, s.iteratorOpts.Opt)
+func (s *Snapshot) Lookup(key []byte) ([]byte, bool, error) {
+	value, found, err := s.db.get(s.readOpts, key)
+	runtime.KeepAlive(s)
+	return value, found, err
+}
+
+func (s *Snapshot) Has(key []byte) (bool, error) {
+	_, found, err := s.db.get(s.readOpts, key)
+	runtime.KeepAlive(s)
+	return found, err
+}
+
+//like DB.MultiGet, reading from s
+func (s *Snapshot) MultiGet(keys [][]byte) ([][]byte, []bool, error) {
+	values, found, err := s.db.multiGet(s.readOpts, keys)
+	runtime.KeepAlive(s)
+	return values, found, err
+}
 
+//the iterator keeps reading from s even if s is closed first
+func (s *Snapshot) NewIterator() *Iterator {
+	it := s.db.newIterator(s.iteratorOpts)
+	runtime.KeepAlive(s)
 	return it
 }
 
@@ -41,6 +92,14 @@ func (s *Snapshot) RevRangeIterator(min []byte, max []byte, rangeType uint8) *Ra
 	return NewRevRangeLimitIterator(s.NewIterator(), &Range{min, max, rangeType}, &Limit{0, -1})
 }
 
+func (s *Snapshot) PrefixIterator(prefix []byte) *RangeLimitIterator {
+	return NewRangeLimitIterator(s.NewIterator(), prefixRange(prefix), &Limit{0, -1})
+}
+
+func (s *Snapshot) RevPrefixIterator(prefix []byte) *RangeLimitIterator {
+	return NewRevRangeLimitIterator(s.NewIterator(), prefixRange(prefix), &Limit{0, -1})
+}
+
 //count < 0, unlimit
 //offset must >= 0, if < 0, will get nothing
 func (s *Snapshot) RangeLimitIterator(min []byte, max []byte, rangeType uint8, offset int, count int) *RangeLimitIterator {
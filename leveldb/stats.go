@@ -0,0 +1,192 @@
+package leveldb
+
+// #cgo LDFLAGS: -lleveldb
+// #include <stdlib.h>
+// #include "leveldb/c.h"
+import "C"
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// leveldb reports compaction stats in MB
+const statsMB = 1048576
+
+type LevelStats struct {
+	Level int
+
+	//number of sstables and their total size in bytes
+	Files int
+	Size  int64
+
+	//accumulated compaction work for this level,
+	//leveldb only reports read and write with MB precision
+	CompactionTime  time.Duration
+	CompactionRead  int64
+	CompactionWrite int64
+}
+
+type Stats struct {
+	Levels []LevelStats
+
+	//approximate bytes used by memtables and block cache,
+	//0 if the leveldb library does not support it
+	MemoryUsage int64
+}
+
+// GetProperty returns the value of a leveldb property such as
+// "leveldb.stats", "leveldb.num-files-at-level<N>" or "leveldb.sstables",
+// or "" if the property is unknown or the database is closed.
+func (db *DB) GetProperty(name string) string {
+	if err := db.acquire(); err != nil {
+		return ""
+	}
+	defer db.release()
+
+	return db.getProperty(name)
+}
+
+// getProperty is GetProperty for callers holding a reference to db.
+func (db *DB) getProperty(name string) string {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	value := C.leveldb_property_value(db.db, cname)
+	if value == nil {
+		return ""
+	}
+
+	defer C.leveldb_free(unsafe.Pointer(value))
+	return C.GoString(value)
+}
+
+// Stats collects and parses leveldb's per level properties.
+func (db *DB) Stats() (*Stats, error) {
+	if err := db.acquire(); err != nil {
+		return nil, err
+	}
+	defer db.release()
+
+	s := new(Stats)
+
+	for level := 0; ; level++ {
+		v := db.getProperty(fmt.Sprintf("leveldb.num-files-at-level%d", level))
+		if len(v) == 0 {
+			break
+		}
+
+		files, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid num-files-at-level%d %q", level, v)
+		}
+
+		s.Levels = append(s.Levels, LevelStats{Level: level, Files: files})
+	}
+
+	if err := s.parseSSTables(db.getProperty("leveldb.sstables")); err != nil {
+		return nil, err
+	}
+
+	if err := s.parseCompactions(db.getProperty("leveldb.stats")); err != nil {
+		return nil, err
+	}
+
+	if v := db.getProperty("leveldb.approximate-memory-usage"); len(v) > 0 {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid approximate-memory-usage %q", v)
+		}
+		s.MemoryUsage = n
+	}
+
+	return s, nil
+}
+
+func (s *Stats) level(level int) *LevelStats {
+	if level < 0 || level >= len(s.Levels) {
+		return nil
+	}
+	return &s.Levels[level]
+}
+
+// parseSSTables sums the file sizes listed by leveldb.sstables:
+//
+//	--- level 0 ---
+//	 5:1024['a' @ 1 : 1 .. 'b' @ 2 : 1]
+func (s *Stats) parseSSTables(v string) error {
+	var cur *LevelStats
+
+	sc := bufio.NewScanner(strings.NewReader(v))
+	for sc.Scan() {
+		line := sc.Text()
+
+		if strings.HasPrefix(line, "--- level ") {
+			var level int
+			if _, err := fmt.Sscanf(line, "--- level %d ---", &level); err != nil {
+				return fmt.Errorf("invalid sstables line %q", line)
+			}
+			cur = s.level(level)
+			continue
+		}
+
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || cur == nil {
+			continue
+		}
+
+		//number:size[smallest .. largest]
+		var number, size int64
+		if _, err := fmt.Sscanf(line, "%d:%d[", &number, &size); err != nil {
+			return fmt.Errorf("invalid sstables line %q", line)
+		}
+
+		cur.Size += size
+	}
+
+	return sc.Err()
+}
+
+// parseCompactions reads the compaction table of leveldb.stats:
+//
+//	                               Compactions
+//	Level  Files Size(MB) Time(sec) Read(MB) Write(MB)
+//	--------------------------------------------------
+//	  0        1        0         0        0         0
+func (s *Stats) parseCompactions(v string) error {
+	sc := bufio.NewScanner(strings.NewReader(v))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 6 {
+			continue
+		}
+
+		level, err := strconv.Atoi(fields[0])
+		if err != nil {
+			//header line
+			continue
+		}
+
+		var n [5]float64
+		for i := range n {
+			if n[i], err = strconv.ParseFloat(fields[i+1], 64); err != nil {
+				return fmt.Errorf("invalid stats line %q", sc.Text())
+			}
+		}
+
+		l := s.level(level)
+		if l == nil {
+			continue
+		}
+
+		l.CompactionTime = time.Duration(n[2] * float64(time.Second))
+		l.CompactionRead = int64(n[3] * statsMB)
+		l.CompactionWrite = int64(n[4] * statsMB)
+	}
+
+	return sc.Err()
+}
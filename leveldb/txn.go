@@ -0,0 +1,165 @@
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Txn is an optimistic transaction. It reads from a snapshot taken by
+// Begin, sees its own pending writes, and remembers the keys it read with
+// Get, Lookup and Has. Commit fails with ErrConflict if the value of any of
+// those keys changed since the snapshot, otherwise the writes are committed
+// atomically.
+//
+// Commits of transactions are serialized by a lock of the DB, so conflicts
+// between transactions are always detected. Commit also locks the keys read
+// and written against Update and the other read-modify-write functions of DB.
+// Plain writes to the DB take no lock, a write racing with a Commit may go
+// unnoticed.
+// Keys read through iterators are not remembered.
+//
+// A Txn is not safe for concurrent use, it must be ended with Commit or
+// Rollback to release its snapshot.
+type Txn struct {
+	db *DB
+
+	snap  *Snapshot
+	batch *IndexedBatch
+
+	reads map[string]struct{}
+}
+
+// Begin starts a transaction reading from a snapshot of db.
+func (db *DB) Begin() *Txn {
+	t := new(Txn)
+	t.db = db
+	t.snap = db.NewSnapshot()
+	t.batch = t.snap.NewIndexedBatch()
+	t.reads = make(map[string]struct{})
+	return t
+}
+
+func (t *Txn) errDone() error {
+	return newError(ErrClosed, t.db.cfg.Path, "transaction is done")
+}
+
+func (t *Txn) Get(key []byte) ([]byte, error) {
+	value, _, err := t.Lookup(key)
+	return value, err
+}
+
+func (t *Txn) Lookup(key []byte) ([]byte, bool, error) {
+	if t.batch == nil {
+		return nil, false, t.errDone()
+	}
+
+	t.reads[string(key)] = struct{}{}
+	return t.batch.Lookup(key)
+}
+
+func (t *Txn) Has(key []byte) (bool, error) {
+	if t.batch == nil {
+		return false, t.errDone()
+	}
+
+	t.reads[string(key)] = struct{}{}
+	return t.batch.Has(key)
+}
+
+func (t *Txn) Put(key, value []byte) error {
+	if t.batch == nil {
+		return t.errDone()
+	}
+	return t.batch.Put(key, value)
+}
+
+func (t *Txn) Delete(key []byte) error {
+	if t.batch == nil {
+		return t.errDone()
+	}
+	return t.batch.Delete(key)
+}
+
+// NewIterator iterates the snapshot merged with the pending writes.
+// The keys it visits are not checked for conflicts. Once the transaction
+// is done the iterator is invalid and its Error returns ErrClosed.
+func (t *Txn) NewIterator() *Iterator {
+	if t.batch == nil {
+		return &Iterator{db: t.db, impl: errIterator{t.errDone()}, cmp: t.db.cmp}
+	}
+	return t.batch.NewIterator()
+}
+
+// Commit checks the keys read for conflicts and commits the writes,
+// the transaction is done afterwards, also on errors.
+func (t *Txn) Commit() error {
+	if t.batch == nil {
+		return t.errDone()
+	}
+	defer t.Rollback()
+
+	t.db.txnLock.Lock()
+	defer t.db.txnLock.Unlock()
+
+	keys := make([][]byte, 0, len(t.reads)+len(t.batch.kvs))
+	for k := range t.reads {
+		keys = append(keys, []byte(k))
+	}
+	for _, kv := range t.batch.kvs {
+		keys = append(keys, kv.key)
+	}
+
+	unlock := t.db.keyLocks.lock(keys...)
+	defer unlock()
+
+	for k := range t.reads {
+		key := []byte(k)
+
+		old, oldFound, err := t.snap.Lookup(key)
+		if err != nil {
+			return err
+		}
+
+		cur, curFound, err := t.db.Lookup(key)
+		if err != nil {
+			return err
+		}
+
+		if oldFound != curFound || string(old) != string(cur) {
+			return newError(ErrConflict, t.db.cfg.Path, fmt.Sprintf("key %q changed", k))
+		}
+	}
+
+	return t.batch.Commit()
+}
+
+// Rollback discards the writes, it does nothing if the transaction is done.
+func (t *Txn) Rollback() {
+	if t.batch == nil {
+		return
+	}
+
+	t.batch.Close()
+	t.snap.Close()
+	t.batch = nil
+	t.snap = nil
+	t.reads = nil
+}
+
+// RunTxn runs fn in a new transaction and commits it, retrying with a new
+// transaction up to retries times on ErrConflict. If fn returns an error
+// the transaction is rolled back and the error returned.
+func (db *DB) RunTxn(retries int, fn func(t *Txn) error) error {
+	for i := 0; ; i++ {
+		t := db.Begin()
+		if err := fn(t); err != nil {
+			t.Rollback()
+			return err
+		}
+
+		err := t.Commit()
+		if err == nil || !errors.Is(err, ErrConflict) || i >= retries {
+			return err
+		}
+	}
+}
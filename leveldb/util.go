@@ -4,11 +4,31 @@ package leveldb
 import "C"
 
 import (
-	"fmt"
 	"reflect"
 	"unsafe"
 )
 
+// points empty keys and values somewhere valid, leveldb treats
+// a NULL start or limit as unbounded in some calls
+var emptyBytes = []byte{0}
+
+// pointer to the first byte of b for the leveldb C API, never NULL,
+// even for a nil or zero-length b
+func bytesPtr(b []byte) *C.char {
+	if len(b) == 0 {
+		return (*C.char)(unsafe.Pointer(&emptyBytes[0]))
+	}
+	return (*C.char)(unsafe.Pointer(&b[0]))
+}
+
+// copyBytes returns a copy of b, which is nil only if b is nil.
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
 func boolToUchar(b bool) C.uchar {
 	uc := C.uchar(0)
 	if b {
@@ -24,11 +44,11 @@ func ucharToBool(uc C.uchar) bool {
 	return true
 }
 
-func saveError(errStr *C.char) error {
+func saveError(path string, errStr *C.char) error {
 	if errStr != nil {
 		gs := C.GoString(errStr)
 		C.leveldb_free(unsafe.Pointer(errStr))
-		return fmt.Errorf(gs)
+		return newError(classifyError(gs), path, gs)
 	}
 	return nil
 }